@@ -0,0 +1,96 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	_ "github.com/go-sql-driver/mysql"
+	"log"
+	"os"
+	"snippetbox.xyh.net/internal/envelope"
+	"snippetbox.xyh.net/internal/models"
+	"time"
+)
+
+// admin runs maintenance tasks against the snippetbox database, the only one so far rotates the master key:
+//
+//	$ go run ./cmd/admin/ -master-key-file=new.key -old-master-key-file=old.key rotate-key
+//
+// A rotation goes in three steps: restart the web application with the new key and the old key, so new content is
+// sealed with the new key while old rows stay readable, then run rotate-key, then restart the web application with
+// only the new key. rotate-key also encrypts rows that were written before encryption at rest was enabled.
+func main() {
+	dsn := flag.String("dsn", "", "MySQL data source name, built from DB_PASSWORD when empty")
+	masterKeyFile := flag.String("master-key-file", "", "File holding the new base64 encoded master key")
+	oldMasterKeyFile := flag.String("old-master-key-file", "", "File holding the master key being replaced")
+	batchSize := flag.Int("batch", 500, "Number of rows re-encrypted per batch")
+	flag.Parse()
+
+	infoLog := log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
+	errorLog := log.New(os.Stderr, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
+
+	if flag.NArg() != 1 || flag.Arg(0) != "rotate-key" {
+		fmt.Fprintln(os.Stderr, "usage: admin [flags] rotate-key")
+		flag.PrintDefaults()
+		os.Exit(2)
+	}
+
+	keys, err := envelope.LoadKeyring(*masterKeyFile, os.Getenv("SNIPPETBOX_MASTER_KEY"), *oldMasterKeyFile, os.Getenv("SNIPPETBOX_OLD_MASTER_KEY"))
+	if err != nil {
+		errorLog.Fatal(err)
+	}
+	if keys == nil {
+		errorLog.Fatal("a master key is required, set -master-key-file or SNIPPETBOX_MASTER_KEY")
+	}
+
+	if *dsn == "" {
+		*dsn = fmt.Sprintf("xyh:%s@tcp(snippetapp.mysql.database.azure.com:3306)/snippet?parseTime=true&tls=true", os.Getenv("DB_PASSWORD"))
+	}
+	db, err := openDB(*dsn)
+	if err != nil {
+		errorLog.Fatal(err)
+	}
+	defer db.Close()
+
+	snippets := &models.SnippetModel{DB: db, Keys: keys}
+	users := &models.UserModel{DB: db, Keys: keys}
+	infoLog.Printf("Re-encrypting with master key %s", keys.CurrentKeyID())
+
+	//every table holding snippet content and the TOTP secrets, each in batches so no statement holds locks for long
+	for _, t := range []struct {
+		name string
+		run  func(afterID int, batchSize int) (int, int, error)
+	}{
+		{"snippets", snippets.ReencryptSnippets},
+		{"snippet_revisions", snippets.ReencryptRevisions},
+		{"snippet_files", snippets.ReencryptFiles},
+		{"snippet_revision_files", snippets.ReencryptRevisionFiles},
+		{"totp", users.ReencryptTOTPSecrets},
+	} {
+		start := time.Now()
+		afterID, total := 0, 0
+		for {
+			lastID, changed, err := t.run(afterID, *batchSize)
+			if err != nil {
+				errorLog.Fatalf("table=%s after_id=%d: %v", t.name, afterID, err)
+			}
+			if lastID == 0 {
+				break
+			}
+			afterID = lastID
+			total += changed
+		}
+		infoLog.Printf("table=%s reencrypted=%d duration=%s", t.name, total, time.Since(start))
+	}
+}
+
+func openDB(dsn string) (*sql.DB, error) {
+	db, err := sql.Open("mysql", dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err = db.Ping(); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
@@ -3,3 +3,4 @@ package main
 type contextKey string
 
 const isAuthenticatedContextKey = contextKey("isAuthenticated")
+const isVerifiedContextKey = contextKey("isVerified")
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"snippetbox.xyh.net/internal/diff"
+	"snippetbox.xyh.net/internal/models"
+	"snippetbox.xyh.net/internal/validator"
+	"strconv"
+	"strings"
+)
+
+// the form of the diff page, a and b are each one of the snippets of the current user (12), a revision of one (12r3)
+// or the slug of a share link
+type snippetDiffForm struct {
+	A                   string `form:"a"`
+	B                   string `form:"b"`
+	View                string `form:"view"` //unified or split
+	validator.Validator `form:"-"`
+}
+
+// number of unchanged lines shown around every change
+const diffContext = 3
+
+var diffRefRX = regexp.MustCompile(`^(\d+)(?:r(\d+))?$`)
+
+// one side of a comparison
+type diffSide struct {
+	Title     string
+	Files     []*models.File
+	Encrypted bool
+}
+
+// the comparison of the files at the same position of both sides, a file that only exists on one side is compared to
+// an empty one
+type fileDiff struct {
+	AName   string
+	BName   string
+	Hunks   []diff.Hunk
+	Added   int
+	Removed int
+}
+
+// loadDiffSide looks up the snippet or revision named by a reference. Share links are subject to the checks of
+// sharedSnippet, a locked or burn after reading snippet is reported as not found
+func (app *application) loadDiffSide(r *http.Request, ref string) (*diffSide, error) {
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+
+	if match := diffRefRX.FindStringSubmatch(ref); match != nil {
+		id, _ := strconv.Atoi(match[1])
+		if match[2] != "" {
+			revision, _ := strconv.Atoi(match[2])
+			rev, err := app.snippets.GetRevision(id, userID, revision)
+			if err != nil {
+				return nil, err
+			}
+			return &diffSide{Title: fmt.Sprintf("%s r%d", rev.Title, rev.Revision), Files: rev.Files, Encrypted: rev.Encrypted}, nil
+		}
+		snippet, err := app.snippets.Get(id, userID)
+		if err != nil {
+			return nil, err
+		}
+		return &diffSide{Title: snippet.Title, Files: snippet.Files, Encrypted: snippet.Encrypted}, nil
+	}
+
+	snippet, err := app.snippets.GetBySlug(ref)
+	if err != nil {
+		return nil, err
+	}
+	if !app.isUnlocked(r, snippet) || (snippet.BurnAfterRead && snippet.UserID != userID) {
+		return nil, models.ErrNoRecord
+	}
+	return &diffSide{Title: snippet.Title, Files: snippet.Files, Encrypted: snippet.Encrypted}, nil
+}
+
+// fileName returns the name of the file at index i of a side in a diff, files without a name are numbered so both
+// sides of a raw diff agree on it
+func (side *diffSide) fileName(i int) string {
+	if i < len(side.Files) {
+		if name := safeName(side.Files[i].Name); name != "" {
+			return name
+		}
+	}
+	return fmt.Sprintf("file-%d", i+1)
+}
+
+// compareSides diffs the files of both sides position by position
+func compareSides(a, b *diffSide) []*fileDiff {
+	diffs := []*fileDiff{}
+	for i := 0; i < len(a.Files) || i < len(b.Files); i++ {
+		var aContent, bContent string
+		if i < len(a.Files) {
+			aContent = a.Files[i].Content
+		}
+		if i < len(b.Files) {
+			bContent = b.Files[i].Content
+		}
+
+		lines := diff.Lines(aContent, bContent)
+		fd := &fileDiff{AName: a.fileName(i), BName: b.fileName(i), Hunks: diff.Hunks(lines, diffContext)}
+		for _, l := range lines {
+			switch l.Op {
+			case diff.Insert:
+				fd.Added++
+			case diff.Delete:
+				fd.Removed++
+			}
+		}
+		diffs = append(diffs, fd)
+	}
+	return diffs
+}
+
+// loadDiff validates the form and compares both sides, the problems are reported as field errors
+func (app *application) loadDiff(r *http.Request, form *snippetDiffForm) ([]*fileDiff, error) {
+	sides := make([]*diffSide, 2)
+	for i, field := range []string{"a", "b"} {
+		ref := strings.TrimSpace([]string{form.A, form.B}[i])
+		if !validator.NotBlank(ref) {
+			form.AddFieldError(field, "This field cannot be blank")
+			continue
+		}
+		side, err := app.loadDiffSide(r, ref)
+		if err != nil {
+			if errors.Is(err, models.ErrNoRecord) {
+				form.AddFieldError(field, "There is no snippet with this id or share link")
+				continue
+			}
+			return nil, err
+		}
+		//the server only has the ciphertext of encrypted snippets
+		if side.Encrypted {
+			form.AddFieldError(field, "Encrypted snippets can't be compared")
+			continue
+		}
+		sides[i] = side
+	}
+	if !form.Valid() {
+		return nil, nil
+	}
+	return compareSides(sides[0], sides[1]), nil
+}
+
+// compare two snippets or revisions, unified or side by side
+func (app *application) snippetDiff(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	form := snippetDiffForm{A: query.Get("a"), B: query.Get("b"), View: query.Get("view")}
+	if form.View != "split" {
+		form.View = "unified"
+	}
+
+	data := app.newTemplateData(r)
+	//an empty form is shown without errors
+	if form.A != "" || form.B != "" {
+		diffs, err := app.loadDiff(r, &form)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+		data.Diffs = diffs
+	}
+	data.Form = form
+	app.render(w, http.StatusOK, "diff.html", data)
+}
+
+// the same comparison as snippetDiff as a unified diff, which patch and git apply can read
+func (app *application) snippetDiffRaw(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	form := snippetDiffForm{A: query.Get("a"), B: query.Get("b")}
+	diffs, err := app.loadDiff(r, &form)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	if !form.Valid() {
+		app.notFound(w)
+		return
+	}
+
+	var b strings.Builder
+	for _, fd := range diffs {
+		b.WriteString(diff.Unified("a/"+fd.AName, "b/"+fd.BName, fd.Hunks))
+	}
+	w.Header().Set("Content-Type", "text/x-diff; charset=utf-8")
+	w.Write([]byte(b.String()))
+}
+
+// the css class of a line of a diff
+func diffClass(op diff.Op) string {
+	switch op {
+	case diff.Insert:
+		return "added"
+	case diff.Delete:
+		return "removed"
+	}
+	return ""
+}
@@ -1,22 +1,188 @@
 package main
 
 import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/julienschmidt/httprouter"
+	"mime"
 	"net/http"
+	"net/url"
+	"rsc.io/qr"
+	"snippetbox.xyh.net/internal/mailer"
 	"snippetbox.xyh.net/internal/models"
+	"snippetbox.xyh.net/internal/totp"
 	"snippetbox.xyh.net/internal/validator"
 	"strconv"
+	"strings"
+	"time"
 )
 
 // this is used to represent the form data to be sent back to the user in case of a invalid field entry
 // use uppercase to let the html template render it
 // we also used tags to tell decoder how to map HTML form values into different struct fields
 type snippetCreateForm struct {
-	Title               string `form:"title"`
-	Content             string `form:"content"`
-	Expires             int    `form:"expires"` //the decoder will also automatically convert the type to int in this case
+	Title               string            `form:"title"`
+	Files               []snippetFileForm `form:"files"`     //posted as files[0].name, files[0].content, ...
+	Encrypted           bool              `form:"encrypted"` //set by main.js once it has encrypted the content
+	Tags                string            `form:"tags"`      //comma or space separated, see parseTags()
+	Visibility          string            `form:"visibility"`
+	BurnAfterRead       bool              `form:"burn"`
+	Password            string            `form:"password"` //optional, only asked to other users opening the share link
+	ClearPassword       bool              `form:"clear_password"`
+	Expires             int               `form:"expires"` //the decoder will also automatically convert the type to int in this case
+	validator.Validator `form:"-"`
+}
+
+// one of the files of the snippet form
+type snippetFileForm struct {
+	Name     string `form:"name"`
+	Content  string `form:"content"`
+	Language string `form:"language"` //empty to detect it
+}
+
+// the files of the form followed by an empty one, which the template shows to add a file without javascript
+func (form snippetCreateForm) Slots() []snippetFileForm {
+	return append(form.Files[:len(form.Files):len(form.Files)], snippetFileForm{})
+}
+
+// drop the files left empty and validate the others, the errors of a file are reported under file0, file1, ...
+func (form *snippetCreateForm) checkFiles() {
+	files := []snippetFileForm{}
+	for _, f := range form.Files {
+		f.Name = strings.TrimSpace(f.Name)
+		if f.Name != "" || f.Content != "" {
+			files = append(files, f)
+		}
+	}
+	form.Files = files
+
+	form.CheckField(len(files) > 0, "files", "A snippet needs at least one file")
+	form.CheckField(len(files) <= models.MaxFiles, "files", fmt.Sprintf("A snippet cannot have more than %d files", models.MaxFiles))
+	names := map[string]bool{}
+	for i, f := range files {
+		key := fmt.Sprintf("file%d", i)
+		form.CheckField(validator.NotBlank(f.Content), key, "The content cannot be blank")
+		if form.Encrypted {
+			//the server can't read encrypted content, it only checks that it has the expected format
+			form.CheckField(validator.Matches(f.Content, validator.CiphertextRX), key, "The content must be encrypted by the browser, please enable JavaScript")
+		}
+		form.CheckField(validator.MaxChars(f.Name, 100), key, "The name cannot be more than 100 characters long")
+		form.CheckField(validator.Matches(f.Name, validator.FilenameRX), key, "The name cannot contain slashes")
+		form.CheckField(f.Name == "" || !names[f.Name], key, "Another file already has this name")
+		form.CheckField(f.Language == "" || validator.PermittedString(f.Language, languages...), key, "The language must be one of the listed languages")
+		names[f.Name] = true
+	}
+}
+
+// the values of the form as they are written by the snippet model
+func (form *snippetCreateForm) input() models.SnippetInput {
+	files := make([]models.File, len(form.Files))
+	for i, f := range form.Files {
+		files[i] = models.File{Name: f.Name, Content: f.Content, Language: f.Language}
+	}
+	return models.SnippetInput{
+		Title:         form.Title,
+		Files:         files,
+		Encrypted:     form.Encrypted,
+		Tags:          parseTags(form.Tags),
+		Visibility:    form.Visibility,
+		BurnAfterRead: form.BurnAfterRead,
+		Password:      form.Password,
+		ClearPassword: form.ClearPassword,
+		Expires:       form.Expires,
+	}
+}
+
+type snippetUnlockForm struct {
+	Password            string `form:"password"`
+	validator.Validator `form:"-"`
+}
+
+type collectionCreateForm struct {
+	Name                string `form:"name"`
+	Parent              int    `form:"parent"` //0 for a top level collection
+	validator.Validator `form:"-"`
+}
+
+type snippetMoveForm struct {
+	Collection int `form:"collection"` //0 takes the snippet out of its collection
+}
+
+// the forms of the account page, each one is posted on its own
+type accountForms struct {
+	Name     accountNameForm
+	Email    accountEmailForm
+	Password accountPasswordForm
+}
+
+type accountNameForm struct {
+	Name                string `form:"name"`
+	validator.Validator `form:"-"`
+}
+
+type accountEmailForm struct {
+	Email               string `form:"email"`
+	Password            string `form:"password"` //the current password
+	validator.Validator `form:"-"`
+}
+
+type accountPasswordForm struct {
+	CurrentPassword     string `form:"current_password"`
+	NewPassword         string `form:"new_password"`
+	Confirmation        string `form:"confirmation"`
+	validator.Validator `form:"-"`
+}
+
+type accountDeleteForm struct {
+	Password            string `form:"password"`
+	validator.Validator `form:"-"`
+}
+
+// the data.json file of the export of an account, the password hash is left out
+type accountExport struct {
+	User struct {
+		ID       int       `json:"id"`
+		Name     string    `json:"name"`
+		Email    string    `json:"email"`
+		Verified bool      `json:"verified"`
+		Created  time.Time `json:"created"`
+	} `json:"user"`
+	Snippets []snippetExport `json:"snippets"`
+	Exported time.Time       `json:"exported"`
+}
+
+type snippetExport struct {
+	ID            int          `json:"id"`
+	Title         string       `json:"title"`
+	Files         []fileExport `json:"files"`
+	Encrypted     bool         `json:"encrypted"` //the content is the ciphertext, the key is only part of the links
+	Tags          []string     `json:"tags"`
+	Visibility    string       `json:"visibility"`
+	Slug          string       `json:"slug,omitempty"`
+	BurnAfterRead bool         `json:"burn_after_read"`
+	HasPassword   bool         `json:"has_password"`
+	Created       time.Time    `json:"created"`
+	Updated       time.Time    `json:"updated"`
+	Expires       time.Time    `json:"expires"`
+	Deleted       *time.Time   `json:"deleted,omitempty"`
+}
+
+type fileExport struct {
+	Name     string `json:"name"`
+	Language string `json:"language"`
+	Content  string `json:"content"`
+	Path     string `json:"path,omitempty"` //where the file is in the archive, encrypted ones are only in data.json
+}
+
+// the code of an authenticator app or a recovery code, and the password to turn two-factor authentication off or to
+// get new recovery codes
+type userTOTPForm struct {
+	Code                string `form:"code"`
+	Password            string `form:"password"`
 	validator.Validator `form:"-"`
 }
 
@@ -33,6 +199,18 @@ type userLoginForm struct {
 	validator.Validator `form:"-"`
 }
 
+type userPasswordForgotForm struct {
+	Email               string `form:"email"`
+	validator.Validator `form:"-"`
+}
+
+type userPasswordResetForm struct {
+	Token               string `form:"token"`
+	Password            string `form:"password"`
+	Confirmation        string `form:"confirmation"`
+	validator.Validator `form:"-"`
+}
+
 // the signature of the home handler specifies it is a method of the dependency struct *application
 func (app *application) home(w http.ResponseWriter, r *http.Request) {
 	//this url checking is not needed anymore since httprouter matches this exactly
@@ -41,10 +219,11 @@ func (app *application) home(w http.ResponseWriter, r *http.Request) {
 	//	return
 	//}
 
-	//retrieve the last 10 snippets
+	//retrieve the requested page of snippets, the page, size and sort query string parameters are optional
 	//get userId from session data
 	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
-	snippets, err := app.snippets.Latest(userID)
+	opts := listOptions(r)
+	snippets, hasNext, err := app.snippets.List(userID, opts)
 	if err != nil {
 		app.serverError(w, err)
 		return
@@ -53,6 +232,7 @@ func (app *application) home(w http.ResponseWriter, r *http.Request) {
 	//use the helper function to create a struct for holing data that include the current year
 	data := app.newTemplateData(r)
 	data.Snippets = snippets
+	data.Pagination = newPagination(r, opts, hasNext)
 	//we can create the map of the templates once in main.go using the newTemplateCache() in template.go
 	//and then use the render() in helpers.go to execute the chosen template
 	app.render(w, http.StatusOK, "home.html", data)
@@ -82,9 +262,38 @@ func (app *application) snippetView(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	//the previous versions are listed under the snippet
+	revisions, err := app.snippets.Revisions(id, userID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	//and so are the copies other users made of it
+	forks, err := app.snippets.Forks(id, userID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	err = app.snippets.LoadStars(snippet, userID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	//the collections are listed in the form moving the snippet
+	collections, err := app.collections.Tree(userID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
 	//use the helper function to create a struct for holing data that include the current year
 	data := app.newTemplateData(r)
 	data.Snippet = snippet
+	data.Revisions = revisions
+	data.Forks = forks
+	data.Collections = collections
+	data.IsOwner = true
 
 	//flash message is automatically added in the newTemplateDate() function if it exists in the session data
 
@@ -94,177 +303,2000 @@ func (app *application) snippetView(w http.ResponseWriter, r *http.Request) {
 
 }
 
-func (app *application) snippetCreate(w http.ResponseWriter, r *http.Request) {
-	data := app.newTemplateData(r)
-
-	// Initialize a new createSnippetForm instance and pass it to the template. // Notice how this is also a great opportunity to set any default or
-	// 'initial' values for the form --- here we set the initial value for the // snippet expiry to 365 days.
-	data.Form = snippetCreateForm{Expires: 365}
-	app.render(w, http.StatusOK, "create.html", data)
-}
+// view an unlisted or public snippet through its share link, this works for every user including anonymous ones
+func (app *application) snippetShared(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
 
-func (app *application) snippetCreatePost(w http.ResponseWriter, r *http.Request) {
-	//declare a new instance of the instance
-	form := snippetCreateForm{}
-	//the decodePostForm helper function helps with parse and decode forms
-	err := app.decodePostForm(r, &form)
+	slug := params.ByName("slug")
+	snippet, err := app.snippets.GetBySlug(slug)
 	if err != nil {
-		app.clientError(w, http.StatusBadRequest)
+		if errors.Is(err, models.ErrNoRecord) {
+			app.snippetGone(w, r, slug)
+		} else {
+			app.serverError(w, err)
+		}
 		return
 	}
 
-	//we already has an embeded struct Validator in "form" based on its definition
-	//we can use the method of Validator directly
-	//
-	form.CheckField(validator.NotBlank(form.Title), "title", "This field cannot be blank")
-	form.CheckField(validator.MaxChars(form.Title, 100), "title", "This field cannot be more than 100 characters long")
-	form.CheckField(validator.NotBlank(form.Content), "content", "This field cannot be blank")
-	form.CheckField(validator.PermittedInt(form.Expires, 1, 7, 365), "expires", "This field must equal 1, 7 or 365")
+	data := app.newTemplateData(r)
+	data.IsOwner = snippet.UserID == app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
 
-	//if there is invalid entry we need to display it
-	if !form.Valid() {
-		data := app.newTemplateData(r)
-		data.Form = form
-		app.render(w, http.StatusUnprocessableEntity, "create.html", data)
+	//ask for the password first, the snippet is only described by its author until it is unlocked
+	if !app.isUnlocked(r, snippet) {
+		data.Snippet = &models.Snippet{Slug: snippet.Slug, Author: snippet.Author}
+		data.Form = snippetUnlockForm{}
+		app.render(w, http.StatusOK, "unlock.html", data)
 		return
 	}
-	//get userID from session data
-	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
-	//id, err := app.snippets.Insert(form.Title, form.Content, form.Expires, userID)
-	_, err = app.snippets.Insert(form.Title, form.Content, form.Expires, userID)
+
+	//other users have to confirm before a burn after reading snippet is shown, so link previews and crawlers
+	//fetching the url don't burn it
+	if snippet.BurnAfterRead && !data.IsOwner {
+		data.Snippet = &models.Snippet{Slug: snippet.Slug, Author: snippet.Author}
+		app.render(w, http.StatusOK, "burn.html", data)
+		return
+	}
+
+	err = app.snippets.LoadStars(snippet, app.sessionManager.GetInt(r.Context(), "authenticatedUserID"))
 	if err != nil {
 		app.serverError(w, err)
 		return
 	}
-
-	app.sessionManager.Put(r.Context(), "flash", "Snippet created successfully!")
-
-	//http.Redirect(w, r, fmt.Sprintf("/snippet/view/%d", id), http.StatusSeeOther)
-	http.Redirect(w, r, fmt.Sprintf("/"), http.StatusSeeOther)
+	data.Snippet = snippet
+	app.render(w, http.StatusOK, "view.html", data)
 }
 
-func (app *application) userSignup(w http.ResponseWriter, r *http.Request) {
-	data := app.newTemplateData(r)
-	data.Form = userSignupForm{}
-	app.render(w, http.StatusOK, "signup.html", data)
+// show a burn after reading snippet and delete it
+func (app *application) snippetBurnPost(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
 
-}
-func (app *application) userSignupPost(w http.ResponseWriter, r *http.Request) {
-	// Declare an zero-valued instance of our userSignupForm struct.
-	var form userSignupForm
-	//the definition of userSignupForm Struct allows form.Decoder() to work
-	err := app.decodePostForm(r, &form)
+	slug := params.ByName("slug")
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+
+	//a password protected snippet can't be burned before it is unlocked
+	snippet, err := app.snippets.GetBySlug(slug)
 	if err != nil {
-		app.clientError(w, http.StatusBadRequest)
+		if errors.Is(err, models.ErrNoRecord) {
+			app.snippetGone(w, r, slug)
+		} else {
+			app.serverError(w, err)
+		}
 		return
 	}
-
-	// Validate the form contents using our helper functions.
-	form.CheckField(validator.NotBlank(form.Name), "name", "This field cannot be blank")
-	form.CheckField(validator.NotBlank(form.Email), "email", "This field cannot be blank")
-	form.CheckField(validator.Matches(form.Email, validator.EmailRX), "email", "This field must be a valid email address")
-	form.CheckField(validator.NotBlank(form.Password), "password", "This field cannot be blank")
-	form.CheckField(validator.MinChars(form.Password, 8), "password", "This field must be at least 8 characters long")
-
-	//if there is any error in inputs, we need to redisplay the page with a 422 code
-	//however, unlike the error in creating snippets, we are not re-displaying the password
-	if !form.Valid() {
-		data := app.newTemplateData(r)
-		data.Form = form
-		app.render(w, http.StatusUnprocessableEntity, "signup.html", data)
+	if !app.isUnlocked(r, snippet) {
+		http.Redirect(w, r, fmt.Sprintf("/s/%s", slug), http.StatusSeeOther)
 		return
 	}
-	//if no error in input
-	//check if the email is duplicate from the db entry
-	err = app.users.Insert(form.Name, form.Email, form.Password)
+
+	snippet, err = app.snippets.Burn(slug, userID)
 	if err != nil {
-		if errors.Is(err, models.ErrDuplicateEmail) {
-			form.AddFieldError("email", "Email is already in use")
-			//error page returned to the user
-			data := app.newTemplateData(r)
-			data.Form = form
-			app.render(w, http.StatusUnprocessableEntity, "signup.html", data)
+		if errors.Is(err, models.ErrNoRecord) {
+			app.snippetGone(w, r, slug)
 		} else {
 			app.serverError(w, err)
 		}
+		return
 	}
 
-	//otherwise, the signup is successful, and we need to add a flash message to the current session
-	app.sessionManager.Put(r.Context(), "flash", "Account successfully created\nPlease log in.")
-	//redirect to the login paged
-	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
-
-}
-func (app *application) userLogin(w http.ResponseWriter, r *http.Request) {
 	data := app.newTemplateData(r)
-	data.Form = userLoginForm{}
-	app.render(w, http.StatusOK, "login.html", data)
+	data.Snippet = snippet
+	data.IsOwner = snippet.UserID == userID
+	if !data.IsOwner {
+		data.Flash = "This snippet has been burned, it can't be viewed again."
+	}
+	//the content must not be kept by the browser or a proxy either
+	w.Header().Set("Cache-Control", "no-store")
+	app.render(w, http.StatusOK, "view.html", data)
 }
 
-func (app *application) userLoginPost(w http.ResponseWriter, r *http.Request) {
-	var form userLoginForm
-	//value written to form using decode
-	err := app.decodePostForm(r, &form)
+// check the password of a shared snippet and remember in the session that it was unlocked
+func (app *application) snippetUnlockPost(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	slug := params.ByName("slug")
+	snippet, err := app.snippets.GetBySlug(slug)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.snippetGone(w, r, slug)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	var form snippetUnlockForm
+	err = app.decodePostForm(r, &form)
 	if err != nil {
 		app.clientError(w, http.StatusBadRequest)
 		return
 	}
-	// Do some validation checks on the form.
-	form.CheckField(validator.NotBlank(form.Email), "email", "This field cannot be blank")
-	form.CheckField(validator.Matches(form.Email, validator.EmailRX), "email", "This field must be a valid email address")
-	form.CheckField(validator.NotBlank(form.Password), "password", "This field cannot be blank")
 
-	if !form.Valid() {
-		data := app.newTemplateData(r)
+	data := app.newTemplateData(r)
+	data.Snippet = &models.Snippet{Slug: snippet.Slug, Author: snippet.Author}
+
+	//the wrong guesses are counted per snippet, whoever makes them. The attempt is reserved before the password is
+	//checked, bcrypt is slow enough for many concurrent guesses to get in otherwise
+	if !app.unlockLimiter.Hit(slug) {
+		form.AddNonFieldError("Too many wrong passwords, please try again later")
 		data.Form = form
-		app.render(w, http.StatusUnprocessableEntity, "login.tmpl", data)
+		app.render(w, http.StatusTooManyRequests, "unlock.html", data)
 		return
 	}
 
-	// Check whether the credentials are valid. If they're not, add a generic // non-field error message and re-display the login page.
-	id, err := app.users.Authenticate(form.Email, form.Password)
+	err = app.snippets.CheckPassword(slug, form.Password)
+	//only the wrong passwords count, the right one doesn't use up the attempts of the other readers
+	if !errors.Is(err, models.ErrInvalidCredentials) {
+		app.unlockLimiter.Release(slug)
+	}
 	if err != nil {
 		if errors.Is(err, models.ErrInvalidCredentials) {
-			form.AddNonFieldError("Email or password is incorrect")
-			data := app.newTemplateData(r)
+			form.AddFieldError("password", "The password is incorrect")
 			data.Form = form
-			app.render(w, http.StatusUnprocessableEntity, "login.html", data)
+			app.render(w, http.StatusUnprocessableEntity, "unlock.html", data)
+		} else if errors.Is(err, models.ErrNoRecord) {
+			//the password was removed in the meantime
+			http.Redirect(w, r, fmt.Sprintf("/s/%s", slug), http.StatusSeeOther)
 		} else {
 			app.serverError(w, err)
 		}
 		return
 	}
 
-	//renew the session id(change the session id)
-	//it is a good practice to generate a new session once an authentication stage changes(log in or out operation)
-	//this retains the data associated with the session, the reason behind this is to prevent session fixation attack
-	err = app.sessionManager.RenewToken(r.Context())
+	app.sessionManager.Put(r.Context(), unlockedSessionKey(slug), true)
+	http.Redirect(w, r, fmt.Sprintf("/s/%s", slug), http.StatusSeeOther)
+}
+
+// respond to a share link that doesn't lead to a snippet, telling apart burned snippets from unknown ones
+func (app *application) snippetGone(w http.ResponseWriter, r *http.Request, slug string) {
+	burnedAt, err := app.snippets.Burned(slug)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.BurnedAt = burnedAt
+	app.render(w, http.StatusGone, "burned.html", data)
+}
+
+// list the public snippets of every user
+func (app *application) explore(w http.ResponseWriter, r *http.Request) {
+	opts := listOptions(r)
+	snippets, hasNext, err := app.snippets.Explore(opts)
 	if err != nil {
 		app.serverError(w, err)
 		return
 	}
 
-	// Add the ID of the current user to the session, so that they are now // 'logged in'.
-	app.sessionManager.Put(r.Context(), "authenticatedUserID", id)
+	data := app.newTemplateData(r)
+	data.Snippets = snippets
+	data.Pagination = newPagination(r, opts, hasNext)
+	app.render(w, http.StatusOK, "explore.html", data)
+}
 
-	// Redirect the user to the create snippet page.
-	http.Redirect(w, r, "/", http.StatusSeeOther)
+func (app *application) snippetCreate(w http.ResponseWriter, r *http.Request) {
+	data := app.newTemplateData(r)
 
+	// Initialize a new createSnippetForm instance and pass it to the template. // Notice how this is also a great opportunity to set any default or
+	// 'initial' values for the form --- here we set the initial value for the // snippet expiry to 365 days.
+	data.Form = snippetCreateForm{Visibility: models.VisibilityPrivate, Expires: 365}
+	app.render(w, http.StatusOK, "create.html", data)
 }
 
-func (app *application) userLogoutPost(w http.ResponseWriter, r *http.Request) {
-	//good habit to renew sessions
-	err := app.sessionManager.RenewToken(r.Context())
+func (app *application) snippetCreatePost(w http.ResponseWriter, r *http.Request) {
+	//declare a new instance of the instance
+	form := snippetCreateForm{}
+	//the decodePostForm helper function helps with parse and decode forms
+	err := app.decodePostForm(r, &form)
 	if err != nil {
-		app.serverError(w, err)
+		app.clientError(w, http.StatusBadRequest)
 		return
 	}
 
-	//remove the authenticated user id from the session data to let the user log out
-	app.sessionManager.Remove(r.Context(), "authenticatedUserID")
-	//add a flash message to show the user have been logged out
-	app.sessionManager.Put(r.Context(), "flash", "Log out successful")
+	//we already has an embeded struct Validator in "form" based on its definition
+	//we can use the method of Validator directly
+	//
+	form.CheckField(validator.NotBlank(form.Title), "title", "This field cannot be blank")
+	form.CheckField(validator.MaxChars(form.Title, 100), "title", "This field cannot be more than 100 characters long")
+	form.checkFiles()
+	form.CheckField(validator.PermittedInt(form.Expires, 1, 7, 365), "expires", "This field must equal 1, 7 or 365")
+	//burn after reading snippets are only reachable through their share link
+	if form.BurnAfterRead {
+		form.Visibility = models.VisibilityUnlisted
+	}
+	form.CheckField(validator.PermittedString(form.Visibility, models.VisibilityPrivate, models.VisibilityUnlisted, models.VisibilityPublic), "visibility", "This field must equal private, unlisted or public")
+	if form.Password != "" {
+		form.CheckField(validator.MinChars(form.Password, 8), "password", "This field must be at least 8 characters long")
+		//bcrypt only accepts passwords up to 72 bytes
+		form.CheckField(len(form.Password) <= 72, "password", "This field cannot be more than 72 bytes long")
+	}
+	tags := parseTags(form.Tags)
+	form.CheckField(validator.MaxCount(tags, 5), "tags", "This field cannot have more than 5 tags")
+	form.CheckField(validator.AllMaxChars(tags, 30), "tags", "Each tag cannot be more than 30 characters long")
+	form.CheckField(validator.AllMatch(tags, validator.TagRX), "tags", "Tags can only contain letters, digits and the characters _ . + # -")
+
+	//if there is invalid entry we need to display it
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, http.StatusUnprocessableEntity, "create.html", data)
+		return
+	}
+	//get userID from session data
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	id, err := app.snippets.Insert(form.input(), userID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Snippet created successfully!")
+
+	//the key of an encrypted snippet is in the fragment of the url the form was posted to, browsers carry it over to
+	//the redirect, so the owner lands on a page that can decrypt the snippet
+	if form.Encrypted {
+		http.Redirect(w, r, fmt.Sprintf("/snippet/view/%d", id), http.StatusSeeOther)
+		return
+	}
+
+	//http.Redirect(w, r, fmt.Sprintf("/snippet/view/%d", id), http.StatusSeeOther)
+	http.Redirect(w, r, fmt.Sprintf("/"), http.StatusSeeOther)
+}
+
+func (app *application) snippetEdit(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err != nil || id < 1 {
+		app.notFound(w)
+		return
+	}
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	snippet, err := app.snippets.Get(id, userID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Snippet = snippet
+	//pre-populate the form with the current values, an expires value of 0 keeps the current expiry date
+	files := make([]snippetFileForm, len(snippet.Files))
+	for i, f := range snippet.Files {
+		files[i] = snippetFileForm{Name: f.Name, Content: f.Content, Language: f.Language}
+	}
+	data.Form = snippetCreateForm{
+		Title:         snippet.Title,
+		Files:         files,
+		Encrypted:     snippet.Encrypted,
+		Tags:          strings.Join(snippet.Tags, ", "),
+		Visibility:    snippet.Visibility,
+		BurnAfterRead: snippet.BurnAfterRead,
+		Expires:       0,
+	}
+	app.render(w, http.StatusOK, "edit.html", data)
+}
+
+func (app *application) snippetEditPost(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err != nil || id < 1 {
+		app.notFound(w)
+		return
+	}
+
+	//the edit form has the same fields as the create form, so the same struct is reused
+	form := snippetCreateForm{}
+	err = app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.Title), "title", "This field cannot be blank")
+	form.CheckField(validator.MaxChars(form.Title, 100), "title", "This field cannot be more than 100 characters long")
+	form.checkFiles()
+	form.CheckField(validator.PermittedInt(form.Expires, 0, 1, 7, 365), "expires", "This field must equal 0, 1, 7 or 365")
+	//burn after reading snippets are only reachable through their share link
+	if form.BurnAfterRead {
+		form.Visibility = models.VisibilityUnlisted
+	}
+	form.CheckField(validator.PermittedString(form.Visibility, models.VisibilityPrivate, models.VisibilityUnlisted, models.VisibilityPublic), "visibility", "This field must equal private, unlisted or public")
+	if form.Password != "" {
+		form.CheckField(validator.MinChars(form.Password, 8), "password", "This field must be at least 8 characters long")
+		//bcrypt only accepts passwords up to 72 bytes
+		form.CheckField(len(form.Password) <= 72, "password", "This field cannot be more than 72 bytes long")
+	}
+	tags := parseTags(form.Tags)
+	form.CheckField(validator.MaxCount(tags, 5), "tags", "This field cannot have more than 5 tags")
+	form.CheckField(validator.AllMaxChars(tags, 30), "tags", "Each tag cannot be more than 30 characters long")
+	form.CheckField(validator.AllMatch(tags, validator.TagRX), "tags", "Tags can only contain letters, digits and the characters _ . + # -")
+
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+
+	if !form.Valid() {
+		snippet, err := app.snippets.Get(id, userID)
+		if err != nil {
+			if errors.Is(err, models.ErrNoRecord) {
+				app.notFound(w)
+			} else {
+				app.serverError(w, err)
+			}
+			return
+		}
+		data := app.newTemplateData(r)
+		data.Snippet = snippet
+		data.Form = form
+		app.render(w, http.StatusUnprocessableEntity, "edit.html", data)
+		return
+	}
+
+	err = app.snippets.Update(id, userID, form.input())
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Snippet updated successfully!")
+
+	http.Redirect(w, r, fmt.Sprintf("/snippet/view/%d", id), http.StatusSeeOther)
+}
+
+// show a previous version of a snippet, this page is read-only
+func (app *application) snippetRevisionView(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err != nil || id < 1 {
+		app.notFound(w)
+		return
+	}
+	revision, err := strconv.Atoi(params.ByName("revision"))
+	if err != nil || revision < 1 {
+		app.notFound(w)
+		return
+	}
+
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	snippet, err := app.snippets.Get(id, userID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+	rev, err := app.snippets.GetRevision(id, userID, revision)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Snippet = snippet
+	data.Revision = rev
+	app.render(w, http.StatusOK, "revision.html", data)
+}
+
+// return the content of a snippet as plain text, for curl and scripts
+func (app *application) snippetRaw(w http.ResponseWriter, r *http.Request) {
+	snippet, ok := app.ownSnippet(w, r)
+	if ok {
+		app.serveRaw(w, r, snippet, false)
+	}
+}
+
+// the same as snippetRaw, but the browser saves the content as a file
+func (app *application) snippetDownload(w http.ResponseWriter, r *http.Request) {
+	snippet, ok := app.ownSnippet(w, r)
+	if ok {
+		app.serveRaw(w, r, snippet, true)
+	}
+}
+
+// copy one of the snippets of the current user into a new private snippet
+func (app *application) snippetForkPost(w http.ResponseWriter, r *http.Request) {
+	snippet, ok := app.ownSnippet(w, r)
+	if ok {
+		app.fork(w, r, snippet)
+	}
+}
+
+// copy an unlisted or public snippet of any user into a new private snippet of the current user
+func (app *application) snippetSharedForkPost(w http.ResponseWriter, r *http.Request) {
+	snippet, ok := app.sharedSnippet(w, r)
+	if ok {
+		app.fork(w, r, snippet)
+	}
+}
+
+// insert a copy of the title, files and tags of a snippet for the current user. The copy is private and has neither a
+// password nor burns after reading, whatever the original does. An encrypted snippet stays encrypted with the same key,
+// the fork form keeps the key in the fragment of the url so the redirect can show the copy
+func (app *application) fork(w http.ResponseWriter, r *http.Request, snippet *models.Snippet) {
+	files := make([]models.File, len(snippet.Files))
+	for i, f := range snippet.Files {
+		files[i] = *f
+	}
+
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	id, err := app.snippets.Insert(models.SnippetInput{
+		Title:      snippet.Title,
+		Files:      files,
+		Encrypted:  snippet.Encrypted,
+		Tags:       snippet.Tags,
+		Visibility: models.VisibilityPrivate,
+		Expires:    365,
+		ForkedFrom: snippet,
+	}, userID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Snippet forked successfully!")
+	http.Redirect(w, r, fmt.Sprintf("/snippet/view/%d", id), http.StatusSeeOther)
+}
+
+// star a snippet of the current user
+func (app *application) snippetStarPost(w http.ResponseWriter, r *http.Request) {
+	snippet, ok := app.ownSnippet(w, r)
+	if ok {
+		app.star(w, r, snippet, true, fmt.Sprintf("/snippet/view/%d", snippet.ID))
+	}
+}
+
+func (app *application) snippetUnstarPost(w http.ResponseWriter, r *http.Request) {
+	snippet, ok := app.ownSnippet(w, r)
+	if ok {
+		app.star(w, r, snippet, false, fmt.Sprintf("/snippet/view/%d", snippet.ID))
+	}
+}
+
+// star an unlisted or public snippet of any user, with the same checks as viewing it
+func (app *application) snippetSharedStarPost(w http.ResponseWriter, r *http.Request) {
+	snippet, ok := app.sharedSnippet(w, r)
+	if ok {
+		app.star(w, r, snippet, true, fmt.Sprintf("/s/%s", snippet.Slug))
+	}
+}
+
+func (app *application) snippetSharedUnstarPost(w http.ResponseWriter, r *http.Request) {
+	snippet, ok := app.sharedSnippet(w, r)
+	if ok {
+		app.star(w, r, snippet, false, fmt.Sprintf("/s/%s", snippet.Slug))
+	}
+}
+
+// add or remove the star of the current user and go back to the page of the snippet, the forms keep the key of an
+// encrypted snippet in the fragment of the url
+func (app *application) star(w http.ResponseWriter, r *http.Request, snippet *models.Snippet, starred bool, redirect string) {
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	var err error
+	if starred {
+		err = app.snippets.Star(snippet, userID)
+	} else {
+		err = app.snippets.Unstar(snippet, userID)
+	}
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	http.Redirect(w, r, redirect, http.StatusSeeOther)
+}
+
+// list the snippets the current user starred, their own ones and the ones other users shared
+func (app *application) snippetStarred(w http.ResponseWriter, r *http.Request) {
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	opts := listOptions(r)
+	snippets, hasNext, err := app.snippets.Starred(userID, opts)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Snippets = snippets
+	data.Pagination = newPagination(r, opts, hasNext)
+	app.render(w, http.StatusOK, "starred.html", data)
+}
+
+// download every file of a snippet as a zip archive
+func (app *application) snippetZip(w http.ResponseWriter, r *http.Request) {
+	snippet, ok := app.ownSnippet(w, r)
+	if ok {
+		app.serveZip(w, r, snippet)
+	}
+}
+
+// return the content of an unlisted or public snippet as plain text
+func (app *application) snippetSharedRaw(w http.ResponseWriter, r *http.Request) {
+	snippet, ok := app.sharedSnippet(w, r)
+	if ok {
+		app.serveRaw(w, r, snippet, false)
+	}
+}
+
+// the same as snippetSharedRaw, but the browser saves the content as a file
+func (app *application) snippetSharedDownload(w http.ResponseWriter, r *http.Request) {
+	snippet, ok := app.sharedSnippet(w, r)
+	if ok {
+		app.serveRaw(w, r, snippet, true)
+	}
+}
+
+// download every file of an unlisted or public snippet as a zip archive
+func (app *application) snippetSharedZip(w http.ResponseWriter, r *http.Request) {
+	snippet, ok := app.sharedSnippet(w, r)
+	if ok {
+		app.serveZip(w, r, snippet)
+	}
+}
+
+// look up the snippet of the current user named by the id parameter, the same way snippetView does. It writes the
+// error response itself and returns false if there is no such snippet
+func (app *application) ownSnippet(w http.ResponseWriter, r *http.Request) (*models.Snippet, bool) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err != nil || id < 1 {
+		app.notFound(w)
+		return nil, false
+	}
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	snippet, err := app.snippets.Get(id, userID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return nil, false
+	}
+	return snippet, true
+}
+
+// look up the snippet named by the slug parameter with the checks of snippetShared. A password protected snippet has
+// to be unlocked first and a burn after reading snippet can only be read through its page, so a script can't burn it
+// by accident. Both redirect to the share link
+func (app *application) sharedSnippet(w http.ResponseWriter, r *http.Request) (*models.Snippet, bool) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	slug := params.ByName("slug")
+	snippet, err := app.snippets.GetBySlug(slug)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.snippetGone(w, r, slug)
+		} else {
+			app.serverError(w, err)
+		}
+		return nil, false
+	}
+
+	isOwner := snippet.UserID == app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	if !app.isUnlocked(r, snippet) || (snippet.BurnAfterRead && !isOwner) {
+		http.Redirect(w, r, fmt.Sprintf("/s/%s", slug), http.StatusSeeOther)
+		return nil, false
+	}
+	return snippet, true
+}
+
+// write the content of one of the files of a snippet as plain text, the file parameter picks it and defaults to the
+// first one. Encrypted snippets are returned as the ciphertext since the server doesn't have their key.
+// http.ServeContent answers conditional requests with 304 Not Modified using the ETag, a hash of the content, and
+// Last-Modified, the time of the last edit
+func (app *application) serveRaw(w http.ResponseWriter, r *http.Request, snippet *models.Snippet, attachment bool) {
+	index := 0
+	if value := r.URL.Query().Get("file"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > len(snippet.Files) {
+			app.notFound(w)
+			return
+		}
+		index = n - 1
+	}
+	file := snippet.Files[index]
+
+	sum := sha256.Sum256([]byte(file.Content))
+	w.Header().Set("ETag", fmt.Sprintf(`"%x"`, sum[:16]))
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	//snippets can be private or behind a password, so only the browser may keep a copy and it has to revalidate it
+	w.Header().Set("Cache-Control", "private, no-cache")
+	if attachment {
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": downloadName(snippet, index)}))
+	}
+	http.ServeContent(w, r, "", snippet.Updated, strings.NewReader(file.Content))
+}
+
+// write every file of a snippet into a zip archive, with the same caching as serveRaw
+func (app *application) serveZip(w http.ResponseWriter, r *http.Request, snippet *models.Snippet) {
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	used := map[string]bool{}
+	for i := range snippet.Files {
+		//two files can end up with the same name once it is made safe, the later ones get a number
+		name := downloadName(snippet, i)
+		for n := 2; used[name]; n++ {
+			name = fmt.Sprintf("%d-%s", n, downloadName(snippet, i))
+		}
+		used[name] = true
+
+		//the entries carry the time of the last edit, so the same snippet always gives the same archive
+		f, err := archive.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: snippet.Updated})
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+		_, err = f.Write([]byte(snippet.Files[i].Content))
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+	}
+	err := archive.Close()
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	sum := sha256.Sum256(buf.Bytes())
+	w.Header().Set("ETag", fmt.Sprintf(`"%x"`, sum[:16]))
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Cache-Control", "private, no-cache")
+	name := safeName(snippet.Title)
+	if name == "" {
+		name = fmt.Sprintf("snippet-%d", snippet.ID)
+	}
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name + ".zip"}))
+	http.ServeContent(w, r, "", snippet.Updated, bytes.NewReader(buf.Bytes()))
+}
+
+// move a snippet into the trash, it can still be restored from the trash page until it is purged
+func (app *application) snippetDeletePost(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err != nil || id < 1 {
+		app.notFound(w)
+		return
+	}
+
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	err = app.snippets.Delete(id, userID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Snippet moved to the trash")
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+func (app *application) snippetTrash(w http.ResponseWriter, r *http.Request) {
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	snippets, err := app.snippets.Trash(userID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Snippets = snippets
+	data.TrashRetentionDays = int(app.trashRetention.Hours() / 24)
+	app.render(w, http.StatusOK, "trash.html", data)
+}
+
+func (app *application) snippetRestorePost(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err != nil || id < 1 {
+		app.notFound(w)
+		return
+	}
+
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	err = app.snippets.Restore(id, userID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Snippet restored")
+	http.Redirect(w, r, fmt.Sprintf("/snippet/view/%d", id), http.StatusSeeOther)
+}
+
+// permanently delete a snippet, only snippets that are already in the trash can be purged
+func (app *application) snippetPurgePost(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err != nil || id < 1 {
+		app.notFound(w)
+		return
+	}
+
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	err = app.snippets.Purge(id, userID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Snippet permanently deleted")
+	http.Redirect(w, r, "/snippet/trash", http.StatusSeeOther)
+}
+
+// search the snippets of the current user, the query comes from the q query string parameter
+func (app *application) snippetSearch(w http.ResponseWriter, r *http.Request) {
+	data := app.newTemplateData(r)
+	data.Query = r.URL.Query().Get("q")
+	data.TitleSearchOnly = !app.snippets.SearchesContent()
+
+	//nothing is searched for an empty or oversized query or a user who is not logged in
+	if !validator.NotBlank(data.Query) || !validator.MaxChars(data.Query, 100) || !data.IsAuthenticated {
+		app.render(w, http.StatusOK, "search.html", data)
+		return
+	}
+
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	opts := listOptions(r)
+	results, hasNext, err := app.snippets.Search(userID, data.Query, opts)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	data.SearchResults = results
+	data.Pagination = newPagination(r, opts, hasNext)
+	app.render(w, http.StatusOK, "search.html", data)
+}
+
+// list the snippets of the current user carrying a tag
+func (app *application) tagView(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	tag := params.ByName("name")
+	if !validator.Matches(tag, validator.TagRX) {
+		app.notFound(w)
+		return
+	}
+
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	opts := listOptions(r)
+	snippets, hasNext, err := app.snippets.ListByTag(userID, tag, opts)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Tag = tag
+	data.Snippets = snippets
+	data.Pagination = newPagination(r, opts, hasNext)
+	app.render(w, http.StatusOK, "tag.html", data)
+}
+
+// list the collections of the current user as a tree
+func (app *application) collectionIndex(w http.ResponseWriter, r *http.Request) {
+	collections, err := app.collections.Tree(app.sessionManager.GetInt(r.Context(), "authenticatedUserID"))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Collections = collections
+	app.render(w, http.StatusOK, "collection_index.html", data)
+}
+
+// list the snippets of the current user in a collection, with the collections nested in it
+func (app *application) collectionView(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err != nil || id < 1 {
+		app.notFound(w)
+		return
+	}
+
+	//the tree gives the path and the children of the collection, one that isn't part of it belongs to someone else
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	collections, err := app.collections.Tree(userID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	collection := models.Find(collections, id)
+	if collection == nil {
+		app.notFound(w)
+		return
+	}
+
+	opts := listOptions(r)
+	snippets, hasNext, err := app.snippets.ListByCollection(userID, id, opts)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Collection = collection
+	data.Snippets = snippets
+	data.Pagination = newPagination(r, opts, hasNext)
+	app.render(w, http.StatusOK, "collection.html", data)
+}
+
+func (app *application) collectionCreate(w http.ResponseWriter, r *http.Request) {
+	//the parent is picked from the collections of the user
+	collections, err := app.collections.Tree(app.sessionManager.GetInt(r.Context(), "authenticatedUserID"))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Collections = collections
+	//the collection page links here with its id to create a collection inside it
+	parent, _ := strconv.Atoi(r.URL.Query().Get("parent"))
+	data.Form = collectionCreateForm{Parent: parent}
+	app.render(w, http.StatusOK, "collection_create.html", data)
+}
+
+func (app *application) collectionCreatePost(w http.ResponseWriter, r *http.Request) {
+	var form collectionCreateForm
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	collections, err := app.collections.Tree(userID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Collections = collections
+	//the siblings of the new collection, to find out if the name is taken
+	siblings := data.Collections
+	form.Name = strings.TrimSpace(form.Name)
+	form.CheckField(validator.NotBlank(form.Name), "name", "This field cannot be blank")
+	form.CheckField(validator.MaxChars(form.Name, 50), "name", "This field cannot be more than 50 characters long")
+	form.CheckField(!strings.Contains(form.Name, "/"), "name", "This field cannot contain a slash")
+	if form.Parent != 0 {
+		parent := models.Find(data.Collections, form.Parent)
+		if parent == nil {
+			form.AddFieldError("parent", "This collection does not exist")
+		} else {
+			form.CheckField(parent.Depth+1 < models.MaxCollectionDepth, "parent",
+				fmt.Sprintf("Collections can't be nested more than %d levels deep", models.MaxCollectionDepth))
+			siblings = parent.Children
+		}
+	}
+	for _, c := range siblings {
+		form.CheckField(c.Name != form.Name, "name", "There already is a collection with this name here")
+	}
+
+	if !form.Valid() {
+		data.Form = form
+		app.render(w, http.StatusUnprocessableEntity, "collection_create.html", data)
+		return
+	}
+
+	id, err := app.collections.Insert(userID, form.Parent, form.Name)
+	if err != nil {
+		//another request added the same name in the meantime
+		if errors.Is(err, models.ErrDuplicateCollection) {
+			form.AddFieldError("name", "There already is a collection with this name here")
+			data.Form = form
+			app.render(w, http.StatusUnprocessableEntity, "collection_create.html", data)
+		} else if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Collection created")
+	http.Redirect(w, r, fmt.Sprintf("/collection/view/%d", id), http.StatusSeeOther)
+}
+
+// delete a collection, its snippets and nested collections move to its parent
+func (app *application) collectionDeletePost(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err != nil || id < 1 {
+		app.notFound(w)
+		return
+	}
+
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	err = app.collections.Delete(id, userID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else if errors.Is(err, models.ErrDuplicateCollection) {
+			app.sessionManager.Put(r.Context(), "flash", "A collection inside this one has the same name as one next to it, rename it first")
+			http.Redirect(w, r, fmt.Sprintf("/collection/view/%d", id), http.StatusSeeOther)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Collection deleted")
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// put a snippet into one of the collections of its owner, or take it out of its collection
+func (app *application) snippetMovePost(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err != nil || id < 1 {
+		app.notFound(w)
+		return
+	}
+
+	var form snippetMoveForm
+	err = app.decodePostForm(r, &form)
+	if err != nil || form.Collection < 0 {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	err = app.snippets.Move(id, userID, form.Collection)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Snippet moved")
+	http.Redirect(w, r, fmt.Sprintf("/snippet/view/%d", id), http.StatusSeeOther)
+}
+
+func (app *application) userSignup(w http.ResponseWriter, r *http.Request) {
+	data := app.newTemplateData(r)
+	data.Form = userSignupForm{}
+	app.render(w, http.StatusOK, "signup.html", data)
+
+}
+func (app *application) userSignupPost(w http.ResponseWriter, r *http.Request) {
+	// Declare an zero-valued instance of our userSignupForm struct.
+	var form userSignupForm
+	//the definition of userSignupForm Struct allows form.Decoder() to work
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	// Validate the form contents using our helper functions.
+	form.CheckField(validator.NotBlank(form.Name), "name", "This field cannot be blank")
+	form.CheckField(validator.NotBlank(form.Email), "email", "This field cannot be blank")
+	form.CheckField(validator.Matches(form.Email, validator.EmailRX), "email", "This field must be a valid email address")
+	form.CheckField(validator.NotBlank(form.Password), "password", "This field cannot be blank")
+	form.CheckField(validator.MinChars(form.Password, 8), "password", "This field must be at least 8 characters long")
+
+	//if there is any error in inputs, we need to redisplay the page with a 422 code
+	//however, unlike the error in creating snippets, we are not re-displaying the password
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, http.StatusUnprocessableEntity, "signup.html", data)
+		return
+	}
+	//if no error in input
+	//check if the email is duplicate from the db entry
+	id, err := app.users.Insert(form.Name, form.Email, form.Password)
+	if err != nil {
+		if errors.Is(err, models.ErrDuplicateEmail) {
+			form.AddFieldError("email", "Email is already in use")
+			//error page returned to the user
+			data := app.newTemplateData(r)
+			data.Form = form
+			app.render(w, http.StatusUnprocessableEntity, "signup.html", data)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	//the new account can't create snippets until the link sent to its address is followed. If the email can't be
+	//sent, the user can ask for another one once logged in
+	err = app.sendVerification(&models.User{ID: id, Name: form.Name, Email: form.Email})
+	if err != nil {
+		app.errorLog.Printf("sending a verification email to user %d: %v", id, err)
+	}
+
+	//otherwise, the signup is successful, and we need to add a flash message to the current session
+	app.sessionManager.Put(r.Context(), "flash", "Account successfully created\nPlease follow the link we emailed you and log in.")
+	//redirect to the login paged
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+
+}
+func (app *application) userLogin(w http.ResponseWriter, r *http.Request) {
+	data := app.newTemplateData(r)
+	data.Form = userLoginForm{}
+	app.render(w, http.StatusOK, "login.html", data)
+}
+
+func (app *application) userLoginPost(w http.ResponseWriter, r *http.Request) {
+	var form userLoginForm
+	//value written to form using decode
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	// Do some validation checks on the form.
+	form.CheckField(validator.NotBlank(form.Email), "email", "This field cannot be blank")
+	form.CheckField(validator.Matches(form.Email, validator.EmailRX), "email", "This field must be a valid email address")
+	form.CheckField(validator.NotBlank(form.Password), "password", "This field cannot be blank")
+
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, http.StatusUnprocessableEntity, "login.tmpl", data)
+		return
+	}
+
+	// Check whether the credentials are valid. If they're not, add a generic // non-field error message and re-display the login page.
+	id, err := app.users.Authenticate(form.Email, form.Password)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidCredentials) {
+			form.AddNonFieldError("Email or password is incorrect")
+			data := app.newTemplateData(r)
+			data.Form = form
+			app.render(w, http.StatusUnprocessableEntity, "login.html", data)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	//renew the session id(change the session id)
+	//it is a good practice to generate a new session once an authentication stage changes(log in or out operation)
+	//this retains the data associated with the session, the reason behind this is to prevent session fixation attack
+	err = app.sessionManager.RenewToken(r.Context())
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	//with two-factor authentication the password is only the first step. The session remembers who entered it and
+	//when, but the user isn't logged in until the code of their app is entered as well
+	user, err := app.users.Get(id)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	if user.TOTPEnabled {
+		app.sessionManager.Put(r.Context(), "passwordVerifiedUserID", id)
+		app.sessionManager.Put(r.Context(), "passwordVerifiedAt", time.Now().Unix())
+		http.Redirect(w, r, "/user/login/totp", http.StatusSeeOther)
+		return
+	}
+
+	// Add the ID of the current user to the session, so that they are now // 'logged in'.
+	app.sessionManager.Put(r.Context(), "authenticatedUserID", id)
+	err = app.recordSession(r, id)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	// Redirect the user to the create snippet page.
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+
+}
+
+// the second step of the login for the users with two-factor authentication
+func (app *application) userLoginTOTP(w http.ResponseWriter, r *http.Request) {
+	if app.passwordVerifiedUser(r) == 0 {
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+	data := app.newTemplateData(r)
+	data.Form = userTOTPForm{}
+	app.render(w, http.StatusOK, "login_totp.html", data)
+}
+
+func (app *application) userLoginTOTPPost(w http.ResponseWriter, r *http.Request) {
+	userID := app.passwordVerifiedUser(r)
+	if userID == 0 {
+		app.sessionManager.Put(r.Context(), "flash", "Your login has expired, please enter your password again")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	var form userTOTPForm
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.Code = strings.TrimSpace(form.Code)
+	form.CheckField(validator.NotBlank(form.Code), "code", "This field cannot be blank")
+
+	data := app.newTemplateData(r)
+	if !form.Valid() {
+		data.Form = form
+		app.render(w, http.StatusUnprocessableEntity, "login_totp.html", data)
+		return
+	}
+
+	//the wrong codes are counted per user, the password was right so this is the account being attacked. The attempt
+	//is reserved before the code is checked, so concurrent guesses can't all get in before one is counted
+	key := strconv.Itoa(userID)
+	if !app.totpLimiter.Hit(key) {
+		form.AddNonFieldError("Too many wrong codes, please try again later")
+		data.Form = form
+		app.render(w, http.StatusTooManyRequests, "login_totp.html", data)
+		return
+	}
+
+	secret, enabled, err := app.users.TOTPSecret(userID)
+	if err != nil && !errors.Is(err, models.ErrNoRecord) {
+		app.serverError(w, err)
+		return
+	}
+
+	//two-factor authentication may have been turned off since the password was entered, then there is nothing to check
+	ok, usedRecoveryCode := !enabled, false
+	if enabled {
+		//a code of the app, each period only once, otherwise one of the recovery codes
+		if step, valid := totp.Validate(secret, form.Code, time.Now()); valid {
+			ok, err = app.users.UseTOTPStep(userID, step)
+		} else {
+			ok, err = app.users.UseRecoveryCode(userID, form.Code)
+			usedRecoveryCode = ok
+		}
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+	}
+	if !ok {
+		form.AddFieldError("code", "The code is incorrect or was already used")
+		data.Form = form
+		app.render(w, http.StatusUnprocessableEntity, "login_totp.html", data)
+		return
+	}
+	app.totpLimiter.Reset(key)
+
+	//the user is only logged in now, the session id changes again
+	err = app.sessionManager.RenewToken(r.Context())
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	app.sessionManager.Remove(r.Context(), "passwordVerifiedUserID")
+	app.sessionManager.Remove(r.Context(), "passwordVerifiedAt")
+	app.sessionManager.Put(r.Context(), "authenticatedUserID", userID)
+	err = app.recordSession(r, userID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	if usedRecoveryCode {
+		left, err := app.users.RecoveryCodesLeft(userID)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+		app.sessionManager.Put(r.Context(), "flash", fmt.Sprintf("You used a recovery code, %d of them are left", left))
+	}
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+func (app *application) userLogoutPost(w http.ResponseWriter, r *http.Request) {
+	//good habit to renew sessions
+	err := app.sessionManager.RenewToken(r.Context())
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	//remove the authenticated user id from the session data to let the user log out
+	app.sessionManager.Remove(r.Context(), "authenticatedUserID")
+	//add a flash message to show the user have been logged out
+	app.sessionManager.Put(r.Context(), "flash", "Log out successful")
 
 	//redirect to the home page
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
+
+// serve the stylesheet of the syntax highlighting theme, it only changes when the server is restarted
+func (app *application) syntaxStylesheet(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/css; charset=utf-8")
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	w.Write(app.syntaxCSS)
+}
+
+// how long the link of a password reset email can be used
+const passwordResetTTL = time.Hour
+
+func (app *application) userPasswordForgot(w http.ResponseWriter, r *http.Request) {
+	data := app.newTemplateData(r)
+	data.Form = userPasswordForgotForm{}
+	app.render(w, http.StatusOK, "forgot.html", data)
+}
+
+// email a link to reset the password to the address, if it belongs to a user. The answer is the same either way so
+// the form can't be used to find out who has an account
+func (app *application) userPasswordForgotPost(w http.ResponseWriter, r *http.Request) {
+	var form userPasswordForgotForm
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.Email = strings.TrimSpace(form.Email)
+	form.CheckField(validator.NotBlank(form.Email), "email", "This field cannot be blank")
+	form.CheckField(validator.Matches(form.Email, validator.EmailRX), "email", "This field must be a valid email address")
+
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, http.StatusUnprocessableEntity, "forgot.html", data)
+		return
+	}
+
+	//a few emails per address, so the form can't be used to flood an inbox
+	key := strings.ToLower(form.Email)
+	if app.resetLimiter.Hit(key) {
+		user, err := app.users.GetByEmail(form.Email)
+		if err != nil && !errors.Is(err, models.ErrNoRecord) {
+			app.serverError(w, err)
+			return
+		}
+		//the email is sent after the response, waiting for the mail server would make the answer slower for the
+		//addresses that have an account
+		if user != nil {
+			app.background(func() {
+				err := app.sendPasswordReset(user)
+				if err != nil {
+					//not shown to the user, that would tell them the address has an account
+					app.errorLog.Printf("sending a password reset email to user %d: %v", user.ID, err)
+				}
+			})
+		}
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "If an account uses this address, an email with a link to reset its password is on its way")
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+// create a password reset token for the user and email them the link containing it
+func (app *application) sendPasswordReset(user *models.User) error {
+	token, err := app.users.NewToken(user.ID, models.ScopePasswordReset, passwordResetTTL)
+	if err != nil {
+		return err
+	}
+
+	link := fmt.Sprintf("%s/user/password/reset?token=%s", app.baseURL, url.QueryEscape(token))
+	return app.mailer.Send(mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your SnippetGo password",
+		Body: fmt.Sprintf("Hi %s,\n\nsomeone asked to reset the password of your SnippetGo account. If it was you, choose a new "+
+			"password here within the next hour:\n\n%s\n\nIf it wasn't you, you can ignore this email, your password "+
+			"stays the same.\n", user.Name, link),
+	})
+}
+
+func (app *application) userPasswordReset(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	err := app.users.CheckToken(token, models.ScopePasswordReset)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidToken) {
+			app.sessionManager.Put(r.Context(), "flash", "This link has expired or was already used, ask for a new one")
+			http.Redirect(w, r, "/user/password/forgot", http.StatusSeeOther)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Form = userPasswordResetForm{Token: token}
+	app.render(w, http.StatusOK, "reset.html", data)
+}
+
+func (app *application) userPasswordResetPost(w http.ResponseWriter, r *http.Request) {
+	var form userPasswordResetForm
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.Password), "password", "This field cannot be blank")
+	form.CheckField(validator.MinChars(form.Password, 8), "password", "This field must be at least 8 characters long")
+	//bcrypt only accepts passwords up to 72 bytes
+	form.CheckField(len(form.Password) <= 72, "password", "This field cannot be more than 72 bytes long")
+	form.CheckField(form.Password == form.Confirmation, "confirmation", "The passwords don't match")
+
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, http.StatusUnprocessableEntity, "reset.html", data)
+		return
+	}
+
+	userID, err := app.users.ResetPassword(form.Token, form.Password)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidToken) {
+			app.sessionManager.Put(r.Context(), "flash", "This link has expired or was already used, ask for a new one")
+			http.Redirect(w, r, "/user/password/forgot", http.StatusSeeOther)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	//the reset may be because someone else knows the old password, so every session of the account is ended, and
+	//whoever was logged in with this browser has to log in again with the new password too
+	err = app.users.DeleteSessions(userID, "")
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	err = app.sessionManager.RenewToken(r.Context())
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	app.sessionManager.Remove(r.Context(), "authenticatedUserID")
+
+	app.sessionManager.Put(r.Context(), "flash", "Your password has been reset, you can log in with it now")
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+// how long the link of a verification email can be used
+const verificationTTL = 24 * time.Hour
+
+// create an email verification token for the user and email them the link containing it
+func (app *application) sendVerification(user *models.User) error {
+	token, err := app.users.NewToken(user.ID, models.ScopeEmailVerification, verificationTTL)
+	if err != nil {
+		return err
+	}
+
+	link := fmt.Sprintf("%s/user/verify?token=%s", app.baseURL, url.QueryEscape(token))
+	return app.mailer.Send(mailer.Message{
+		To:      user.Email,
+		Subject: "Verify your SnippetGo email address",
+		Body: fmt.Sprintf("Hi %s,\n\nwelcome to SnippetGo! Please confirm that this is your email address by following "+
+			"this link within the next 24 hours:\n\n%s\n\nIf you didn't sign up, you can ignore this email.\n", user.Name, link),
+	})
+}
+
+// verify the email address of a user with the token of the link sent to it, without a token the page explains why
+// snippets can't be created yet and offers to send the link again
+func (app *application) userVerify(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		data := app.newTemplateData(r)
+		app.render(w, http.StatusOK, "verify.html", data)
+		return
+	}
+
+	_, err := app.users.Verify(token)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidToken) {
+			app.sessionManager.Put(r.Context(), "flash", "This link has expired or was already used")
+			http.Redirect(w, r, "/user/verify", http.StatusSeeOther)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Your email address is verified, thank you!")
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// send the verification link of the current user again
+func (app *application) userVerifyResendPost(w http.ResponseWriter, r *http.Request) {
+	if app.isVerified(r) {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	//the email is counted before it is sent, so a burst of posts can't all get through before the first is recorded
+	if !app.verifyLimiter.Hit(strconv.Itoa(userID)) {
+		app.sessionManager.Put(r.Context(), "flash", "Too many emails were sent, please wait a while before asking for another one")
+		http.Redirect(w, r, "/user/verify", http.StatusSeeOther)
+		return
+	}
+
+	user, err := app.users.Get(userID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	err = app.sendVerification(user)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", fmt.Sprintf("We sent a new link to %s", user.Email))
+	http.Redirect(w, r, "/user/verify", http.StatusSeeOther)
+}
+
+// show the details of the current user with the forms changing them
+func (app *application) account(w http.ResponseWriter, r *http.Request) {
+	app.renderAccount(w, r, http.StatusOK, accountForms{})
+}
+
+// render the account page with the forms, one of them holding the values and errors of a rejected post
+func (app *application) renderAccount(w http.ResponseWriter, r *http.Request, status int, forms accountForms) {
+	user, err := app.users.Get(app.sessionManager.GetInt(r.Context(), "authenticatedUserID"))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	if forms.Name.Name == "" && forms.Name.Valid() {
+		forms.Name.Name = user.Name
+	}
+
+	data := app.newTemplateData(r)
+	data.User = user
+	data.Form = forms
+	data.TOTPAvailable = app.users.TOTPAvailable()
+	app.render(w, status, "account.html", data)
+}
+
+func (app *application) accountNamePost(w http.ResponseWriter, r *http.Request) {
+	var form accountNameForm
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.Name = strings.TrimSpace(form.Name)
+	form.CheckField(validator.NotBlank(form.Name), "name", "This field cannot be blank")
+	form.CheckField(validator.MaxChars(form.Name, 255), "name", "This field cannot be more than 255 characters long")
+
+	if !form.Valid() {
+		app.renderAccount(w, r, http.StatusUnprocessableEntity, accountForms{Name: form})
+		return
+	}
+
+	err = app.users.UpdateName(app.sessionManager.GetInt(r.Context(), "authenticatedUserID"), form.Name)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Your name has been changed")
+	http.Redirect(w, r, "/account", http.StatusSeeOther)
+}
+
+// change the email address of the current user, who has to verify the new one before creating snippets again
+func (app *application) accountEmailPost(w http.ResponseWriter, r *http.Request) {
+	var form accountEmailForm
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.Email = strings.TrimSpace(form.Email)
+	form.CheckField(validator.NotBlank(form.Email), "email", "This field cannot be blank")
+	form.CheckField(validator.Matches(form.Email, validator.EmailRX), "email", "This field must be a valid email address")
+	form.CheckField(validator.NotBlank(form.Password), "password", "This field cannot be blank")
+
+	if !form.Valid() {
+		form.Password = ""
+		app.renderAccount(w, r, http.StatusUnprocessableEntity, accountForms{Email: form})
+		return
+	}
+
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	err = app.users.CheckPassword(userID, form.Password)
+	if err == nil {
+		err = app.users.UpdateEmail(userID, form.Email)
+	}
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidCredentials) {
+			form.AddFieldError("password", "The password is incorrect")
+		} else if errors.Is(err, models.ErrDuplicateEmail) {
+			form.AddFieldError("email", "Email is already in use")
+		} else {
+			app.serverError(w, err)
+			return
+		}
+		form.Password = ""
+		app.renderAccount(w, r, http.StatusUnprocessableEntity, accountForms{Email: form})
+		return
+	}
+
+	//the same as on login, the session changes with the identity it belongs to
+	err = app.sessionManager.RenewToken(r.Context())
+	if err == nil {
+		err = app.recordSession(r, userID)
+	}
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	user, err := app.users.Get(userID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	err = app.sendVerification(user)
+	if err != nil {
+		app.errorLog.Printf("sending a verification email to user %d: %v", userID, err)
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", fmt.Sprintf("Your email address has been changed, please follow the link we sent to %s", user.Email))
+	http.Redirect(w, r, "/account", http.StatusSeeOther)
+}
+
+func (app *application) accountPasswordPost(w http.ResponseWriter, r *http.Request) {
+	var form accountPasswordForm
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.CurrentPassword), "current_password", "This field cannot be blank")
+	form.CheckField(validator.NotBlank(form.NewPassword), "new_password", "This field cannot be blank")
+	form.CheckField(validator.MinChars(form.NewPassword, 8), "new_password", "This field must be at least 8 characters long")
+	//bcrypt only accepts passwords up to 72 bytes
+	form.CheckField(len(form.NewPassword) <= 72, "new_password", "This field cannot be more than 72 bytes long")
+	form.CheckField(form.NewPassword == form.Confirmation, "confirmation", "The passwords don't match")
+
+	//the passwords are never shown again
+	if !form.Valid() {
+		app.renderAccount(w, r, http.StatusUnprocessableEntity, accountForms{Password: accountPasswordForm{Validator: form.Validator}})
+		return
+	}
+
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	err = app.users.UpdatePassword(userID, form.CurrentPassword, form.NewPassword)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidCredentials) {
+			form.AddFieldError("current_password", "The password is incorrect")
+			app.renderAccount(w, r, http.StatusUnprocessableEntity, accountForms{Password: accountPasswordForm{Validator: form.Validator}})
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	//the other sessions were logged in with the old password, only the one that changed it stays logged in
+	err = app.sessionManager.RenewToken(r.Context())
+	if err == nil {
+		err = app.recordSession(r, userID)
+	}
+	if err == nil {
+		err = app.users.DeleteSessions(userID, app.sessionManager.Token(r.Context()))
+	}
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Your password has been changed, you were logged out everywhere else")
+	http.Redirect(w, r, "/account", http.StatusSeeOther)
+}
+
+// the two-factor authentication settings. A user who hasn't turned it on gets a secret to scan, the same one until they
+// confirm it so reloading the page doesn't invalidate what was already scanned
+func (app *application) accountTOTP(w http.ResponseWriter, r *http.Request) {
+	app.renderTOTP(w, r, http.StatusOK, userTOTPForm{}, nil)
+}
+
+func (app *application) renderTOTP(w http.ResponseWriter, r *http.Request, status int, form userTOTPForm, codes []string) {
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	user, err := app.users.Get(userID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.User = user
+	data.Form = form
+	data.RecoveryCodes = codes
+	data.TOTPAvailable = app.users.TOTPAvailable()
+
+	//without a master key the secret can't be stored, the page only says so
+	if !user.TOTPEnabled && !data.TOTPAvailable {
+		app.render(w, status, "totp.html", data)
+		return
+	}
+
+	if user.TOTPEnabled {
+		data.RecoveryLeft, err = app.users.RecoveryCodesLeft(userID)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+	} else {
+		data.TOTPSecret, _, err = app.users.TOTPSecret(userID)
+		if errors.Is(err, models.ErrNoRecord) {
+			data.TOTPSecret, err = totp.NewSecret()
+			if err == nil {
+				err = app.users.SetTOTPSecret(userID, data.TOTPSecret)
+			}
+		}
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+	}
+	app.render(w, status, "totp.html", data)
+}
+
+// the QR code of the secret being set up, drawn here so the secret never goes to another service. It is gone once two
+// factor authentication is on
+func (app *application) accountTOTPQR(w http.ResponseWriter, r *http.Request) {
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	secret, enabled, err := app.users.TOTPSecret(userID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+	if enabled {
+		app.notFound(w)
+		return
+	}
+	user, err := app.users.Get(userID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	code, err := qr.Encode(totp.URI("SnippetGo", user.Email, secret), qr.M)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	code.Scale = 6
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(code.PNG())
+}
+
+func (app *application) accountTOTPEnablePost(w http.ResponseWriter, r *http.Request) {
+	var form userTOTPForm
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.Code = strings.TrimSpace(form.Code)
+	form.CheckField(validator.NotBlank(form.Code), "code", "This field cannot be blank")
+	if !form.Valid() {
+		app.renderTOTP(w, r, http.StatusUnprocessableEntity, form, nil)
+		return
+	}
+
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	secret, enabled, err := app.users.TOTPSecret(userID)
+	if err != nil && !errors.Is(err, models.ErrNoRecord) {
+		app.serverError(w, err)
+		return
+	}
+	//already on, or the page was never opened
+	if err != nil || enabled {
+		http.Redirect(w, r, "/account/totp", http.StatusSeeOther)
+		return
+	}
+
+	//a code from the app shows it was set up with the right secret
+	step, ok := totp.Validate(secret, form.Code, time.Now())
+	if !ok {
+		form.AddFieldError("code", "The code is incorrect, check the time of your device")
+		app.renderTOTP(w, r, http.StatusUnprocessableEntity, form, nil)
+		return
+	}
+
+	codes, err := app.users.EnableTOTP(userID, step)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			http.Redirect(w, r, "/account/totp", http.StatusSeeOther)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	err = app.sessionManager.RenewToken(r.Context())
+	if err == nil {
+		err = app.recordSession(r, userID)
+	}
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	//the recovery codes are shown on this response and never again
+	app.renderTOTP(w, r, http.StatusOK, userTOTPForm{}, codes)
+}
+
+// turning two-factor authentication off and getting new recovery codes both ask for the password, so someone at an
+// unattended computer can't do it
+func (app *application) checkTOTPPassword(w http.ResponseWriter, r *http.Request) (int, bool) {
+	var form userTOTPForm
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return 0, false
+	}
+
+	form.CheckField(validator.NotBlank(form.Password), "password", "This field cannot be blank")
+	if !form.Valid() {
+		app.renderTOTP(w, r, http.StatusUnprocessableEntity, userTOTPForm{Validator: form.Validator}, nil)
+		return 0, false
+	}
+
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	err = app.users.CheckPassword(userID, form.Password)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidCredentials) {
+			form.AddFieldError("password", "The password is incorrect")
+			app.renderTOTP(w, r, http.StatusUnprocessableEntity, userTOTPForm{Validator: form.Validator}, nil)
+		} else {
+			app.serverError(w, err)
+		}
+		return 0, false
+	}
+	return userID, true
+}
+
+func (app *application) accountTOTPDisablePost(w http.ResponseWriter, r *http.Request) {
+	userID, ok := app.checkTOTPPassword(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.users.DisableTOTP(userID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	err = app.sessionManager.RenewToken(r.Context())
+	if err == nil {
+		err = app.recordSession(r, userID)
+	}
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Two-factor authentication has been turned off")
+	http.Redirect(w, r, "/account", http.StatusSeeOther)
+}
+
+func (app *application) accountTOTPRecoveryPost(w http.ResponseWriter, r *http.Request) {
+	userID, ok := app.checkTOTPPassword(w, r)
+	if !ok {
+		return
+	}
+
+	_, enabled, err := app.users.TOTPSecret(userID)
+	if err != nil && !errors.Is(err, models.ErrNoRecord) {
+		app.serverError(w, err)
+		return
+	}
+	if !enabled {
+		http.Redirect(w, r, "/account/totp", http.StatusSeeOther)
+		return
+	}
+
+	codes, err := app.users.NewRecoveryCodes(userID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	app.renderTOTP(w, r, http.StatusOK, userTOTPForm{}, codes)
+}
+
+// download all the data of the current user as a zip archive: data.json with the account and every snippet, and the
+// files of the snippets that aren't encrypted under snippets/<id>/
+func (app *application) accountExport(w http.ResponseWriter, r *http.Request) {
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	user, err := app.users.Get(userID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	snippets, err := app.snippets.Export(userID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	export := accountExport{Snippets: []snippetExport{}, Exported: time.Now().UTC()}
+	export.User.ID = user.ID
+	export.User.Name = user.Name
+	export.User.Email = user.Email
+	export.User.Verified = user.Verified
+	export.User.Created = user.Created
+
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	for _, snippet := range snippets {
+		se := snippetExport{
+			ID:            snippet.ID,
+			Title:         snippet.Title,
+			Files:         []fileExport{},
+			Encrypted:     snippet.Encrypted,
+			Tags:          snippet.Tags,
+			Visibility:    snippet.Visibility,
+			Slug:          snippet.Slug,
+			BurnAfterRead: snippet.BurnAfterRead,
+			HasPassword:   snippet.HasPassword,
+			Created:       snippet.Created,
+			Updated:       snippet.Updated,
+			Expires:       snippet.Expires,
+		}
+		if !snippet.Deleted.IsZero() {
+			se.Deleted = &snippet.Deleted
+		}
+
+		used := map[string]bool{}
+		for i, file := range snippet.Files {
+			fe := fileExport{Name: file.Name, Language: file.Language, Content: file.Content}
+			if !snippet.Encrypted {
+				//the same names as the zip of a single snippet
+				name := downloadName(snippet, i)
+				for n := 2; used[name]; n++ {
+					name = fmt.Sprintf("%d-%s", n, downloadName(snippet, i))
+				}
+				used[name] = true
+				fe.Path = fmt.Sprintf("snippets/%d/%s", snippet.ID, name)
+
+				f, err := archive.CreateHeader(&zip.FileHeader{Name: fe.Path, Method: zip.Deflate, Modified: snippet.Updated})
+				if err != nil {
+					app.serverError(w, err)
+					return
+				}
+				_, err = f.Write([]byte(file.Content))
+				if err != nil {
+					app.serverError(w, err)
+					return
+				}
+			}
+			se.Files = append(se.Files, fe)
+		}
+		export.Snippets = append(export.Snippets, se)
+	}
+
+	f, err := archive.CreateHeader(&zip.FileHeader{Name: "data.json", Method: zip.Deflate, Modified: export.Exported})
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	err = enc.Encode(export)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	err = archive.Close()
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	name := fmt.Sprintf("snippetgo-%s.zip", export.Exported.Format("2006-01-02"))
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+	w.Write(buf.Bytes())
+}
+
+// ask for the password before deleting the account of the current user
+func (app *application) accountDelete(w http.ResponseWriter, r *http.Request) {
+	data := app.newTemplateData(r)
+	data.Form = accountDeleteForm{}
+	app.render(w, http.StatusOK, "delete.html", data)
+}
+
+// delete the account of the current user with all their snippets, and log them out everywhere
+func (app *application) accountDeletePost(w http.ResponseWriter, r *http.Request) {
+	var form accountDeleteForm
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.Password), "password", "This field cannot be blank")
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	if form.Valid() {
+		err = app.users.CheckPassword(userID, form.Password)
+		if errors.Is(err, models.ErrInvalidCredentials) {
+			form.AddFieldError("password", "The password is incorrect")
+		} else if err != nil {
+			app.serverError(w, err)
+			return
+		}
+	}
+
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Form = accountDeleteForm{Validator: form.Validator}
+		app.render(w, http.StatusUnprocessableEntity, "delete.html", data)
+		return
+	}
+
+	err = app.users.Delete(userID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	//the session of this request was deleted with the others, a new one carries the flash message
+	err = app.sessionManager.RenewToken(r.Context())
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	app.sessionManager.Remove(r.Context(), "authenticatedUserID")
+
+	app.sessionManager.Put(r.Context(), "flash", "Your account and all your snippets have been deleted. Goodbye!")
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
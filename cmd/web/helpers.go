@@ -7,7 +7,11 @@ import (
 	"github.com/go-playground/form/v4"
 	"net/http"
 	"runtime/debug"
+	"snippetbox.xyh.net/internal/models"
+	"strconv"
+	"strings"
 	"time"
+	"unicode"
 )
 
 // The serverError helper writes an error message and stack trace to the errorLog,
@@ -58,12 +62,55 @@ func (app *application) render(w http.ResponseWriter, status int, page string, d
 }
 
 func (app *application) newTemplateData(r *http.Request) *templateData {
-	return &templateData{
+	data := &templateData{
 		CurrentYear: time.Now().Year(),
 		// Add the flash message to the template data, if one exists.
 		Flash:           app.sessionManager.PopString(r.Context(), "flash"),
 		IsAuthenticated: app.isAuthenticated(r),
+		CurrentUserID:   app.sessionManager.GetInt(r.Context(), "authenticatedUserID"),
+		IsVerified:      app.isVerified(r),
+		Lines:           parseLines(r.URL.Query().Get("lines")),
 	}
+	return data
+}
+
+// a range of lines in one of the files of a snippet, the files are numbered from 1. The zero value selects nothing
+type lineRange struct {
+	File  int
+	First int
+	Last  int
+}
+
+// parse a range of lines written as 12, 12-20 or L12-L20, the same as the anchors of the view page, optionally
+// preceded by the file as in F2-L12-L20. A missing or invalid range returns the zero value
+func parseLines(value string) lineRange {
+	lines := lineRange{File: 1}
+	if strings.HasPrefix(value, "F") {
+		file, rest, _ := strings.Cut(value[1:], "-")
+		n, err := strconv.Atoi(file)
+		if err != nil || n < 1 {
+			return lineRange{}
+		}
+		lines.File, value = n, rest
+	}
+
+	first, last, found := strings.Cut(value, "-")
+	start, err := strconv.Atoi(strings.TrimPrefix(first, "L"))
+	if err != nil || start < 1 {
+		return lineRange{}
+	}
+	end := start
+	if found {
+		end, err = strconv.Atoi(strings.TrimPrefix(last, "L"))
+		if err != nil || end < 1 {
+			return lineRange{}
+		}
+	}
+	if end < start {
+		start, end = end, start
+	}
+	lines.First, lines.Last = start, end
+	return lines
 }
 
 // Create a new decodePostForm() helper method. The second parameter here, dst, // is the target destination that we want to decode the form data into.
@@ -95,3 +142,117 @@ func (app *application) isAuthenticated(r *http.Request) bool {
 	}
 	return isAuthenticated
 }
+
+// run fn in a goroutine that outlives the request, main waits for these before exiting. A panic is logged instead of
+// taking the server down, the recover middleware only covers the handlers
+func (app *application) background(fn func()) {
+	app.wg.Add(1)
+	go func() {
+		defer app.wg.Done()
+		defer func() {
+			if err := recover(); err != nil {
+				app.errorLog.Output(2, fmt.Sprintf("%s\n%s", err, debug.Stack()))
+			}
+		}()
+		fn()
+	}()
+}
+
+// record the token of the current session as one of the user's, after it was renewed, so deleting the account can end
+// it
+func (app *application) recordSession(r *http.Request, userID int) error {
+	return app.users.AddSession(userID, app.sessionManager.Token(r.Context()))
+}
+
+// how long a user who entered their password has to enter the code of their authenticator app
+const totpLoginTimeout = 5 * time.Minute
+
+// the user who entered their password but not yet the code of their authenticator app, 0 if there is none or the
+// password was entered more than totpLoginTimeout ago
+func (app *application) passwordVerifiedUser(r *http.Request) int {
+	id := app.sessionManager.GetInt(r.Context(), "passwordVerifiedUserID")
+	at := app.sessionManager.GetInt64(r.Context(), "passwordVerifiedAt")
+	if id == 0 || time.Since(time.Unix(at, 0)) > totpLoginTimeout {
+		return 0
+	}
+	return id
+}
+
+// whether the current user has verified their email address, false if no one is logged in
+func (app *application) isVerified(r *http.Request) bool {
+	isVerified, ok := r.Context().Value(isVerifiedContextKey).(bool)
+	return ok && isVerified
+}
+
+// the session key recording that the password of a shared snippet was entered
+func unlockedSessionKey(slug string) string {
+	return "unlocked:" + slug
+}
+
+// report whether the current user can see the content of a shared snippet, which is the case if it has no password,
+// they own it or they entered its password earlier in the session
+func (app *application) isUnlocked(r *http.Request, snippet *models.Snippet) bool {
+	if !snippet.HasPassword {
+		return true
+	}
+	if snippet.UserID == app.sessionManager.GetInt(r.Context(), "authenticatedUserID") {
+		return true
+	}
+	return app.sessionManager.GetBool(r.Context(), unlockedSessionKey(snippet.Slug))
+}
+
+// split the tags field of the snippet forms on commas and spaces, the tags are lowercased and duplicates are dropped
+func parseTags(value string) []string {
+	seen := map[string]bool{}
+	tags := []string{}
+	for _, tag := range strings.FieldsFunc(strings.ToLower(value), func(r rune) bool { return r == ',' || unicode.IsSpace(r) }) {
+		if !seen[tag] {
+			seen[tag] = true
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// the name of a downloaded file of a snippet: its name, or the title of the snippet if it has none, with anything but
+// letters, digits, dots, dashes and underscores replaced by dashes. A name without an extension gets the one of the
+// language of the file, or .txt
+func downloadName(snippet *models.Snippet, index int) string {
+	file := snippet.Files[index]
+	name := safeName(file.Name)
+	if name == "" {
+		name = safeName(snippet.Title)
+		if name == "" {
+			name = fmt.Sprintf("snippet-%d", snippet.ID)
+		}
+		if index > 0 {
+			name = fmt.Sprintf("%s-%d", name, index+1)
+		}
+	}
+	if strings.Contains(name, ".") {
+		return name
+	}
+
+	ext := ".txt"
+	if !snippet.Encrypted {
+		for _, pattern := range lexerFor(file.Language, file.Name, file.Content).Config().Filenames {
+			//the patterns are globs, only the simple *.go kind maps to an extension
+			if strings.HasPrefix(pattern, "*.") && !strings.ContainsAny(pattern[2:], "*?[") {
+				ext = pattern[1:]
+				break
+			}
+		}
+	}
+	return name + ext
+}
+
+// replace anything but letters, digits, dots, dashes and underscores by dashes, leading and trailing dots are removed
+// so the name can't point to a parent directory
+func safeName(name string) string {
+	return strings.Trim(strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '.' || r == '-' || r == '_' {
+			return r
+		}
+		return '-'
+	}, name), ".-")
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"flag"
 	"fmt"
@@ -12,7 +13,14 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"snippetbox.xyh.net/internal/envelope"
+	"snippetbox.xyh.net/internal/mailer"
 	"snippetbox.xyh.net/internal/models"
+	"snippetbox.xyh.net/internal/ratelimit"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 )
 
@@ -22,27 +30,65 @@ type application struct {
 	infoLog        *log.Logger
 	snippets       *models.SnippetModel
 	users          *models.UserModel
+	collections    *models.CollectionModel
 	templateCache  map[string]*template.Template
 	formDecoder    *form.Decoder
 	sessionManager *scs.SessionManager
+	trashRetention time.Duration
+	reapBatchSize  int
+	unlockLimiter  *ratelimit.Limiter
+	syntaxCSS      []byte
+	mailer         mailer.Mailer
+	baseURL        string //used in the links sent by email, the Host header of a request can't be trusted for them
+	resetLimiter   *ratelimit.Limiter
+	verifyLimiter  *ratelimit.Limiter
+	totpLimiter    *ratelimit.Limiter
+	wg             sync.WaitGroup //the goroutines started by background()
 }
 
 func main() {
 	//creating a command line flag
 	// to call the flag use $ go run ./cmd/web/ -addr=":80"
 	addr := flag.String("addr", ":4000", "HTTP network address")
+	//snippets in the trash are permanently deleted once they are older than this
+	trashRetention := flag.Duration("trash-retention", 30*24*time.Hour, "How long deleted snippets are kept in the trash")
+	//expired snippets and sessions are removed by background workers
+	reapInterval := flag.Duration("reap-interval", 10*time.Minute, "How often expired snippets and sessions are deleted")
+	reapBatchSize := flag.Int("reap-batch", 500, "Maximum number of expired snippets deleted per statement")
+	//snippet content is encrypted at rest when a master key is given, either as a file or in SNIPPETBOX_MASTER_KEY.
+	//during a key rotation the previous key is still needed to read the rows that haven't been re-encrypted yet
+	masterKeyFile := flag.String("master-key-file", "", "File holding the base64 encoded master key")
+	oldMasterKeyFile := flag.String("old-master-key-file", "", "File holding the previous master key during a rotation")
+	//any of the chroma styles, see https://xyproto.github.io/splash/docs/
+	syntaxTheme := flag.String("syntax-theme", "github", "Colour theme used to highlight the snippets")
+	//emails are sent through an SMTP server when a host is given, otherwise they are written to the mail directory or,
+	//without one, to the console. The SMTP password is read from SMTP_PASSWORD
+	baseURL := flag.String("base-url", "http://localhost:4000", "Address of the site, used in the links sent by email")
+	smtpHost := flag.String("smtp-host", "", "SMTP server used to send emails")
+	smtpPort := flag.Int("smtp-port", 587, "Port of the SMTP server")
+	smtpUsername := flag.String("smtp-username", "", "SMTP username, empty to send without authentication")
+	mailSender := flag.String("mail-sender", "SnippetGo <no-reply@snippetgo.local>", "From address of the emails")
+	mailDir := flag.String("mail-dir", "", "Directory the emails are written to when no SMTP server is given")
 
 	//add a command line flag for the mysql data source name string
 	//dsn := flag.String("dsn", "web:1234@/snippetbox?parseTime=true", "MySQL data source name")
 	//dsn := flag.String("dsn", "xyh:${DB_PASSWORD}@tcp(snippetapp.mysql.database.azure.com:3306)/snippet?parseTime=true&tls=true", "MySQL data source name")
-	////parse the command line flag
-	//flag.Parse()
+	//parse the command line flag
+	flag.Parse()
 
 	//create new loggers to separate information and errors.
 	infoLog := log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
 	errorLog := log.New(os.Stderr, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
 	//putting these 2 log dependencies into the struct
 
+	//a ticker can't tick every 0 seconds, and a reaper deleting 0 rows at a time never finishes
+	if *reapInterval <= 0 {
+		errorLog.Fatal("-reap-interval must be greater than 0")
+	}
+	if *reapBatchSize <= 0 {
+		errorLog.Fatal("-reap-batch must be greater than 0")
+	}
+
 	//create the connection pool
 	//db, err := openDB(*dsn)
 
@@ -58,40 +104,113 @@ func main() {
 	//close the connection pool before the main() function is closed
 	defer db.Close()
 
+	keys, err := envelope.LoadKeyring(*masterKeyFile, os.Getenv("SNIPPETBOX_MASTER_KEY"), *oldMasterKeyFile, os.Getenv("SNIPPETBOX_OLD_MASTER_KEY"))
+	if err != nil {
+		errorLog.Fatal(err)
+	}
+	if keys != nil {
+		infoLog.Printf("Encrypting snippets at rest with master key %s", keys.CurrentKeyID())
+	} else {
+		infoLog.Print("No master key, snippets are stored as plain text and two-factor authentication can't be set up")
+	}
+
 	//initialize a new template cache
 	templateCache, err := newTemplateCache()
 	if err != nil {
 		errorLog.Fatal(err)
 	}
 
+	//the stylesheet of the syntax highlighting theme is generated once
+	syntaxCSS, err := syntaxCSS(*syntaxTheme)
+	if err != nil {
+		errorLog.Fatal(err)
+	}
+
+	var mail mailer.Mailer
+	switch {
+	case *smtpHost != "":
+		mail = &mailer.SMTP{Host: *smtpHost, Port: *smtpPort, Username: *smtpUsername, Password: os.Getenv("SMTP_PASSWORD"), Sender: *mailSender}
+	case *mailDir != "":
+		mail = &mailer.Dir{Path: *mailDir, Sender: *mailSender}
+	default:
+		mail = &mailer.Log{Logger: infoLog, Sender: *mailSender}
+	}
+
 	//initialize a form decoder instance
 	formDecoder := form.NewDecoder()
 
 	//initialize a new session manager
 	//configure it to use our sql db as the session store, and set a life-time of 12 hours
+	//the cleanup of the store is turned off, the reaper deletes the expired sessions so it can log how many there were
 	sessionManager := scs.New()
-	sessionManager.Store = mysqlstore.New(db)
+	sessionManager.Store = mysqlstore.NewWithCleanupInterval(db, 0)
 	sessionManager.Lifetime = 12 * time.Hour
 
 	app := &application{
 		errorLog:       errorLog,
 		infoLog:        infoLog,
-		snippets:       &models.SnippetModel{DB: db},
-		users:          &models.UserModel{DB: db},
+		snippets:       &models.SnippetModel{DB: db, Keys: keys},
+		users:          &models.UserModel{DB: db, Keys: keys},
+		collections:    &models.CollectionModel{DB: db},
 		templateCache:  templateCache,
 		formDecoder:    formDecoder,
 		sessionManager: sessionManager,
+		trashRetention: *trashRetention,
+		reapBatchSize:  *reapBatchSize,
+		//5 wrong passwords per snippet every 15 minutes
+		unlockLimiter: ratelimit.New(5, 15*time.Minute),
+		syntaxCSS:     syntaxCSS,
+		mailer:        mail,
+		baseURL:       strings.TrimSuffix(*baseURL, "/"),
+		//3 reset emails per address every hour
+		resetLimiter: ratelimit.New(3, time.Hour),
+		//and 3 verification emails per user
+		verifyLimiter: ratelimit.New(3, time.Hour),
+		//5 wrong codes per user every 15 minutes, there are only a million of them
+		totpLimiter: ratelimit.New(5, 15*time.Minute),
 	}
 
+	//this context is cancelled when the process is asked to stop, which stops the background workers
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	//empty the trash and remove expired snippets in the background
+	var workers sync.WaitGroup
+	runEvery(ctx, &workers, time.Hour, app.purgeTrash)
+	runEvery(ctx, &workers, *reapInterval, app.reapExpired)
+
 	srv := &http.Server{
 		Addr:     *addr,
 		ErrorLog: errorLog,
 		Handler:  app.routes(),
 	}
 
-	infoLog.Printf("Starting server on %v\n", *addr)
-	err = srv.ListenAndServe()
-	errorLog.Fatal(err)
+	//serve in a separate goroutine so main() can wait for the shutdown signal
+	serverErr := make(chan error, 1)
+	go func() {
+		infoLog.Printf("Starting server on %v\n", *addr)
+		serverErr <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err = <-serverErr:
+		errorLog.Print(err)
+	case <-ctx.Done():
+		infoLog.Print("Shutting down server")
+		//give the in-flight requests a few seconds to complete
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		err = srv.Shutdown(shutdownCtx)
+		cancel()
+		if err != nil {
+			errorLog.Print(err)
+		}
+	}
+
+	//make sure the workers are stopped before the connection pool is closed
+	stop()
+	workers.Wait()
+	app.wg.Wait()
+	infoLog.Print("Server stopped")
 }
 
 func openDB(dsn string) (*sql.DB, error) {
@@ -2,8 +2,10 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
+	"snippetbox.xyh.net/internal/models"
 )
 
 func secureHeaders(next http.Handler) http.Handler {
@@ -61,6 +63,18 @@ func (app *application) requireAuthentication(next http.Handler) http.Handler {
 	})
 }
 
+// middleware keeping the users who haven't verified their email address from creating snippets, it comes after
+// requireAuthentication
+func (app *application) requireVerification(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !app.isVerified(r) {
+			http.Redirect(w, r, "/user/verify", http.StatusSeeOther)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
 func (app *application) authenticate(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		//check from the session data the user id, default is 0 if not exists
@@ -71,15 +85,17 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 			return
 		}
 		//otherwise, check if the user exists
-		exists, err := app.users.Exists(id)
-		if err != nil {
+		user, err := app.users.Get(id)
+		if err != nil && !errors.Is(err, models.ErrNoRecord) {
 			app.serverError(w, err)
 			return
 		}
-		if exists {
+		if user != nil {
 			//create a copy of the request, add centext filed isAuthenticatedContextKey = true
 			//to it, and then assign it to r
 			ctx := context.WithValue(r.Context(), isAuthenticatedContextKey, true)
+			//and whether the user has verified their email address
+			ctx = context.WithValue(ctx, isVerifiedContextKey, user.Verified)
 			r = r.WithContext(ctx)
 		}
 		next.ServeHTTP(w, r)
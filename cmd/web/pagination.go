@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"snippetbox.xyh.net/internal/models"
+	"strconv"
+)
+
+// pagination holds what the templates need to render the next/previous and sort links of a paginated list
+type pagination struct {
+	Page     int
+	PageSize int
+	Sort     string
+	HasPrev  bool
+	HasNext  bool
+	path     string
+	query    url.Values
+}
+
+// read the page, size and sort query string parameters, invalid values fall back to the defaults
+func listOptions(r *http.Request) models.ListOptions {
+	query := r.URL.Query()
+	//Atoi returns 0 for a missing or malformed value, which Normalize() replaces with the default
+	page, _ := strconv.Atoi(query.Get("page"))
+	size, _ := strconv.Atoi(query.Get("size"))
+
+	opts := models.ListOptions{Page: page, PageSize: size, Sort: query.Get("sort")}
+	opts.Normalize()
+	return opts
+}
+
+func newPagination(r *http.Request, opts models.ListOptions, hasNext bool) *pagination {
+	return &pagination{
+		Page:     opts.Page,
+		PageSize: opts.PageSize,
+		Sort:     opts.Sort,
+		HasPrev:  opts.Page > 1,
+		HasNext:  hasNext,
+		path:     r.URL.EscapedPath(),
+		query:    r.URL.Query(),
+	}
+}
+
+// url returns the current url with the given query string parameters replaced, the other ones (e.g. a search query)
+// are kept
+func (p *pagination) url(page int, sort string) string {
+	query := url.Values{}
+	for k, v := range p.query {
+		query[k] = v
+	}
+	query.Set("page", strconv.Itoa(page))
+	query.Set("size", strconv.Itoa(p.PageSize))
+	query.Set("sort", sort)
+	return p.path + "?" + query.Encode()
+}
+
+func (p *pagination) PrevURL() string {
+	return p.url(p.Page-1, p.Sort)
+}
+
+func (p *pagination) NextURL() string {
+	return p.url(p.Page+1, p.Sort)
+}
+
+// SortURL goes back to the first page since the rows on the other pages change with the order
+func (p *pagination) SortURL(sort string) string {
+	return p.url(1, sort)
+}
@@ -32,6 +32,8 @@ func (app *application) routes() http.Handler {
 	//the fileserver is a fileHandler in itself
 	fileServer := http.FileServer(http.Dir("./ui/static/"))
 	router.Handler(http.MethodGet, "/static/*filepath", http.StripPrefix("/static", fileServer))
+	//the stylesheet of the syntax highlighting theme is generated, so it can't live with the static files
+	router.HandlerFunc(http.MethodGet, "/syntax.css", app.syntaxStylesheet)
 
 	//create a new middleware chain containing the middleware specific to our dynamic router(not including the file server, since it does
 	//not need to be stateful)
@@ -41,19 +43,75 @@ func (app *application) routes() http.Handler {
 	//the advanced routing already takes care of differentiating between GET and POST requests
 	router.Handler(http.MethodGet, "/", dynamic.ThenFunc(app.home))
 	router.Handler(http.MethodGet, "/snippet/view/:id", dynamic.ThenFunc(app.snippetView))
+	router.Handler(http.MethodGet, "/snippet/search", dynamic.ThenFunc(app.snippetSearch))
+	router.Handler(http.MethodGet, "/s/:slug", dynamic.ThenFunc(app.snippetShared))
+	router.Handler(http.MethodPost, "/s/:slug/burn", dynamic.ThenFunc(app.snippetBurnPost))
+	router.Handler(http.MethodPost, "/s/:slug/unlock", dynamic.ThenFunc(app.snippetUnlockPost))
+	router.Handler(http.MethodGet, "/s/:slug/raw", dynamic.ThenFunc(app.snippetSharedRaw))
+	router.Handler(http.MethodGet, "/s/:slug/download", dynamic.ThenFunc(app.snippetSharedDownload))
+	router.Handler(http.MethodGet, "/s/:slug/zip", dynamic.ThenFunc(app.snippetSharedZip))
+	router.Handler(http.MethodGet, "/explore", dynamic.ThenFunc(app.explore))
 
 	// Add the five new routes, all of which use our 'dynamic' middleware chain.
 	router.Handler(http.MethodGet, "/user/signup", dynamic.ThenFunc(app.userSignup))
 	router.Handler(http.MethodPost, "/user/signup", dynamic.ThenFunc(app.userSignupPost))
 	router.Handler(http.MethodGet, "/user/login", dynamic.ThenFunc(app.userLogin))
 	router.Handler(http.MethodPost, "/user/login", dynamic.ThenFunc(app.userLoginPost))
+	router.Handler(http.MethodGet, "/user/login/totp", dynamic.ThenFunc(app.userLoginTOTP))
+	router.Handler(http.MethodPost, "/user/login/totp", dynamic.ThenFunc(app.userLoginTOTPPost))
+	router.Handler(http.MethodGet, "/user/verify", dynamic.ThenFunc(app.userVerify))
+	router.Handler(http.MethodGet, "/user/password/forgot", dynamic.ThenFunc(app.userPasswordForgot))
+	router.Handler(http.MethodPost, "/user/password/forgot", dynamic.ThenFunc(app.userPasswordForgotPost))
+	router.Handler(http.MethodGet, "/user/password/reset", dynamic.ThenFunc(app.userPasswordReset))
+	router.Handler(http.MethodPost, "/user/password/reset", dynamic.ThenFunc(app.userPasswordResetPost))
 
 	// Protected (authenticated-only) application routes, using a new "protected" // middleware chain
 	//which includes the requireAuthentication middleware.
 	protected := dynamic.Append(app.requireAuthentication)
+	//creating snippets also requires a verified email address
+	verified := protected.Append(app.requireVerification)
 
-	router.Handler(http.MethodGet, "/snippet/create", protected.ThenFunc(app.snippetCreate))
-	router.Handler(http.MethodPost, "/snippet/create", protected.ThenFunc(app.snippetCreatePost))
+	router.Handler(http.MethodGet, "/snippet/create", verified.ThenFunc(app.snippetCreate))
+	router.Handler(http.MethodPost, "/snippet/create", verified.ThenFunc(app.snippetCreatePost))
+	router.Handler(http.MethodGet, "/snippet/edit/:id", protected.ThenFunc(app.snippetEdit))
+	router.Handler(http.MethodPost, "/snippet/edit/:id", protected.ThenFunc(app.snippetEditPost))
+	router.Handler(http.MethodGet, "/snippet/view/:id/revision/:revision", protected.ThenFunc(app.snippetRevisionView))
+	router.Handler(http.MethodGet, "/snippet/diff", protected.ThenFunc(app.snippetDiff))
+	router.Handler(http.MethodGet, "/snippet/diff/raw", protected.ThenFunc(app.snippetDiffRaw))
+	router.Handler(http.MethodGet, "/snippet/raw/:id", protected.ThenFunc(app.snippetRaw))
+	router.Handler(http.MethodGet, "/snippet/download/:id", protected.ThenFunc(app.snippetDownload))
+	router.Handler(http.MethodGet, "/snippet/zip/:id", protected.ThenFunc(app.snippetZip))
+	router.Handler(http.MethodPost, "/snippet/delete/:id", protected.ThenFunc(app.snippetDeletePost))
+	router.Handler(http.MethodGet, "/snippet/trash", protected.ThenFunc(app.snippetTrash))
+	router.Handler(http.MethodPost, "/snippet/restore/:id", protected.ThenFunc(app.snippetRestorePost))
+	router.Handler(http.MethodPost, "/snippet/purge/:id", protected.ThenFunc(app.snippetPurgePost))
+	router.Handler(http.MethodPost, "/snippet/fork/:id", verified.ThenFunc(app.snippetForkPost))
+	router.Handler(http.MethodPost, "/s/:slug/fork", verified.ThenFunc(app.snippetSharedForkPost))
+	router.Handler(http.MethodPost, "/snippet/star/:id", protected.ThenFunc(app.snippetStarPost))
+	router.Handler(http.MethodPost, "/snippet/unstar/:id", protected.ThenFunc(app.snippetUnstarPost))
+	router.Handler(http.MethodPost, "/s/:slug/star", protected.ThenFunc(app.snippetSharedStarPost))
+	router.Handler(http.MethodPost, "/s/:slug/unstar", protected.ThenFunc(app.snippetSharedUnstarPost))
+	router.Handler(http.MethodGet, "/snippet/starred", protected.ThenFunc(app.snippetStarred))
+	router.Handler(http.MethodPost, "/snippet/move/:id", protected.ThenFunc(app.snippetMovePost))
+	router.Handler(http.MethodGet, "/tag/:name", protected.ThenFunc(app.tagView))
+	router.Handler(http.MethodGet, "/collection", protected.ThenFunc(app.collectionIndex))
+	router.Handler(http.MethodGet, "/collection/create", protected.ThenFunc(app.collectionCreate))
+	router.Handler(http.MethodPost, "/collection/create", protected.ThenFunc(app.collectionCreatePost))
+	router.Handler(http.MethodGet, "/collection/view/:id", protected.ThenFunc(app.collectionView))
+	router.Handler(http.MethodPost, "/collection/delete/:id", protected.ThenFunc(app.collectionDeletePost))
+	router.Handler(http.MethodGet, "/account", protected.ThenFunc(app.account))
+	router.Handler(http.MethodPost, "/account/name", protected.ThenFunc(app.accountNamePost))
+	router.Handler(http.MethodPost, "/account/email", protected.ThenFunc(app.accountEmailPost))
+	router.Handler(http.MethodPost, "/account/password", protected.ThenFunc(app.accountPasswordPost))
+	router.Handler(http.MethodGet, "/account/totp", protected.ThenFunc(app.accountTOTP))
+	router.Handler(http.MethodGet, "/account/totp/qr.png", protected.ThenFunc(app.accountTOTPQR))
+	router.Handler(http.MethodPost, "/account/totp/enable", protected.ThenFunc(app.accountTOTPEnablePost))
+	router.Handler(http.MethodPost, "/account/totp/disable", protected.ThenFunc(app.accountTOTPDisablePost))
+	router.Handler(http.MethodPost, "/account/totp/recovery", protected.ThenFunc(app.accountTOTPRecoveryPost))
+	router.Handler(http.MethodGet, "/account/export", protected.ThenFunc(app.accountExport))
+	router.Handler(http.MethodGet, "/account/delete", protected.ThenFunc(app.accountDelete))
+	router.Handler(http.MethodPost, "/account/delete", protected.ThenFunc(app.accountDeletePost))
+	router.Handler(http.MethodPost, "/user/verify/resend", protected.ThenFunc(app.userVerifyResendPost))
 	router.Handler(http.MethodPost, "/user/logout", protected.ThenFunc(app.userLogoutPost))
 
 	// Create the middleware chain
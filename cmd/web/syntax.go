@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/alecthomas/chroma/v2"
+	"github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/alecthomas/chroma/v2/lexers"
+	"github.com/alecthomas/chroma/v2/styles"
+	"html/template"
+	"snippetbox.xyh.net/internal/models"
+)
+
+// the languages offered on the snippet form, the names are the ones chroma registers its lexers under.
+// An empty language detects it from the title, or the content if the title is not a file name
+var languages = []string{
+	"Bash", "C", "C#", "C++", "CSS", "Diff", "Docker", "Go", "HTML", "Java", "JavaScript", "JSON", "Kotlin", "Lua",
+	"Makefile", "markdown", "PHP", "plaintext", "PowerShell", "Python", "Ruby", "Rust", "SQL", "Swift", "TOML",
+	"TypeScript", "XML", "YAML",
+}
+
+// the formatter writes css classes instead of style attributes, which the Content-Security-Policy would block. The
+// colours come from the stylesheet served by syntaxStylesheet.
+// Every line starts with its number, which links to the anchor of the line: #L12 in the first file, #F2-L12 in the
+// second one and so on
+var syntaxOptions = []html.Option{html.WithClasses(true), html.WithLineNumbers(true)}
+
+// the prefix of the line anchors of the file at the given position
+func linePrefix(position int) string {
+	if position == 1 {
+		return "L"
+	}
+	return fmt.Sprintf("F%d-L", position)
+}
+
+// lexerFor picks the lexer of a file: the chosen language, else the one matching its name (main.go, Dockerfile), else
+// the one detected from the content, else plain text
+func lexerFor(language, name, content string) chroma.Lexer {
+	lexer := lexers.Get(language)
+	if language == "" || lexer == nil {
+		lexer = lexers.Match(name)
+	}
+	if lexer == nil {
+		lexer = lexers.Analyse(content)
+	}
+	if lexer == nil {
+		lexer = lexers.Fallback
+	}
+	return chroma.Coalesce(lexer)
+}
+
+// escape the content of a file and wrap it in <pre><code>, with a span carrying a css class around every token and every
+// line. The selected lines are highlighted if they are in this file
+func syntax(file *models.File, lines lineRange) template.HTML {
+	content := file.Content
+	options := append(syntaxOptions[:len(syntaxOptions):len(syntaxOptions)], html.WithLinkableLineNumbers(true, linePrefix(file.Position)))
+	if lines.File == file.Position {
+		options = append(options, html.HighlightLines([][2]int{{lines.First, lines.Last}}))
+	}
+
+	iterator, err := lexerFor(file.Language, file.Name, content).Tokenise(nil, content)
+	if err == nil {
+		var buf bytes.Buffer
+		err = html.New(options...).Format(&buf, styles.Fallback, iterator)
+		if err == nil {
+			return template.HTML(buf.String())
+		}
+	}
+	//the content is still shown if it can't be highlighted
+	return template.HTML("<pre><code>" + template.HTMLEscapeString(content) + "</code></pre>")
+}
+
+// syntaxCSS returns the stylesheet of a chroma theme, such as github or monokai
+func syntaxCSS(theme string) ([]byte, error) {
+	style, ok := styles.Registry[theme]
+	if !ok {
+		return nil, fmt.Errorf("unknown syntax theme %q", theme)
+	}
+	var buf bytes.Buffer
+	err := html.New(syntaxOptions...).WriteCSS(&buf, style)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
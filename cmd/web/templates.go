@@ -3,17 +3,45 @@ package main
 import (
 	"html/template"
 	"path/filepath"
+	"regexp"
+	"snippetbox.xyh.net/internal/diff"
 	"snippetbox.xyh.net/internal/models"
+	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
 )
 
 type templateData struct {
 	CurrentYear     int
 	Snippet         *models.Snippet
 	Snippets        []*models.Snippet
+	Revision        *models.Revision
+	Revisions       []*models.Revision
+	Forks           []*models.Snippet
+	Diffs           []*fileDiff //the files compared on the diff page
+	Collection      *models.Collection
+	User            *models.User         //the current user on the account page
+	Collections     []*models.Collection //the collection tree of the current user, only read by the pages showing it
+	RecoveryCodes   []string             //the new recovery codes, they are only shown once
+	RecoveryLeft    int                  //the number of recovery codes that weren't used yet
+	TOTPSecret      string               //the secret being set up, for users who can't scan the QR code
+	TOTPAvailable   bool                 //the server has a master key to encrypt the secrets with
+	Pagination      *pagination
+	SearchResults   []*models.SearchResult
 	Form            any
+	Query           string
+	TitleSearchOnly bool //the content is encrypted at rest, so search only looks at the titles
+	Tag             string
 	Flash           string
 	IsAuthenticated bool
+	CurrentUserID   int       //0 when no one is logged in
+	IsVerified      bool      //the current user has verified their email address
+	IsOwner         bool      //the current user owns .Snippet
+	BurnedAt        time.Time //when the requested burn after reading snippet was read
+	Lines           lineRange //the lines to highlight, from the lines parameter
+	//number of days a snippet stays in the trash before it is purged
+	TrashRetentionDays int
 }
 
 // returns a nicely formated time
@@ -21,9 +49,81 @@ func humanDate(t time.Time) string {
 	return t.Format("02 Jan 2006 at 15:04")
 }
 
+// compile a case-insensitive pattern matching any of the words in a search query, nil if there are none
+func searchPattern(query string) *regexp.Regexp {
+	terms := []string{}
+	for _, term := range strings.FieldsFunc(query, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsNumber(r) }) {
+		terms = append(terms, regexp.QuoteMeta(term))
+	}
+	if len(terms) == 0 {
+		return nil
+	}
+	return regexp.MustCompile("(?i)" + strings.Join(terms, "|"))
+}
+
+// escape text for html and wrap every match of the query words in a <mark> element
+func highlight(text, query string) template.HTML {
+	rx := searchPattern(query)
+	if rx == nil {
+		return template.HTML(template.HTMLEscapeString(text))
+	}
+
+	var b strings.Builder
+	last := 0
+	for _, loc := range rx.FindAllStringIndex(text, -1) {
+		b.WriteString(template.HTMLEscapeString(text[last:loc[0]]))
+		b.WriteString("<mark>")
+		b.WriteString(template.HTMLEscapeString(text[loc[0]:loc[1]]))
+		b.WriteString("</mark>")
+		last = loc[1]
+	}
+	b.WriteString(template.HTMLEscapeString(text[last:]))
+	return template.HTML(b.String())
+}
+
+// number of characters shown on each side of the first match in a search excerpt
+const excerptRadius = 80
+
+// return the part of the content around the first match of the query words, with the matches highlighted
+func excerpt(content, query string) template.HTML {
+	//without a match the excerpt is the beginning of the content
+	start, end := 0, 0
+	if rx := searchPattern(query); rx != nil {
+		if loc := rx.FindStringIndex(content); loc != nil {
+			start, end = loc[0], loc[1]
+		}
+	}
+
+	//widen the window by excerptRadius characters on both sides, moving by runes so no character is cut in half
+	for i := 0; i < excerptRadius && start > 0; i++ {
+		_, size := utf8.DecodeLastRuneInString(content[:start])
+		start -= size
+	}
+	for i := 0; i < excerptRadius && end < len(content); i++ {
+		_, size := utf8.DecodeRuneInString(content[end:])
+		end += size
+	}
+
+	result := highlight(content[start:end], query)
+	if start > 0 {
+		result = "&hellip;" + result
+	}
+	if end < len(content) {
+		result += "&hellip;"
+	}
+	return result
+}
+
 // create a template.FuncMap object, this is basically a lookup map that helps us locate the right function name
 var functions = template.FuncMap{
-	"humanDate": humanDate,
+	"humanDate":  humanDate,
+	"highlight":  highlight,
+	"excerpt":    excerpt,
+	"syntax":     syntax,
+	"languages":  func() []string { return languages },
+	"diffClass":  diffClass,
+	"sideBySide": diff.SideBySide,
+	"flatten":    models.Flatten,
 }
 
 func newTemplateCache() (map[string]*template.Template, error) {
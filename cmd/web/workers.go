@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// runEvery calls fn once straight away and then once every interval until the context is cancelled.
+// the wait group is marked done when the loop exits so main() can wait for in-flight work during shutdown
+func runEvery(ctx context.Context, wg *sync.WaitGroup, interval time.Duration, fn func(ctx context.Context)) {
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			fn(ctx)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// purgeTrash permanently deletes the snippets that have been in the trash for longer than the retention period
+func (app *application) purgeTrash(ctx context.Context) {
+	n, err := app.snippets.PurgeTrash(app.trashRetention)
+	if err != nil {
+		app.errorLog.Printf("purging the trash: %v", err)
+		return
+	}
+	if n > 0 {
+		app.infoLog.Printf("worker=trash purged=%d retention=%s", n, app.trashRetention)
+	}
+}
+
+// reapExpired deletes the expired snippets in batches of reapBatchSize until none are left or the context is cancelled
+func (app *application) reapExpired(ctx context.Context) {
+	start := time.Now()
+	var reaped int64
+	batches := 0
+
+	for ctx.Err() == nil {
+		n, err := app.snippets.DeleteExpired(app.reapBatchSize)
+		if err != nil {
+			app.errorLog.Printf("reaping expired snippets: %v", err)
+			break
+		}
+		reaped += n
+		batches++
+		//a short batch means the backlog is cleared
+		if n < int64(app.reapBatchSize) {
+			break
+		}
+	}
+
+	if reaped > 0 {
+		app.infoLog.Printf("worker=reaper reaped=%d batches=%d duration=%s", reaped, batches, time.Since(start).Round(time.Millisecond))
+	}
+
+	//the sessions that are over
+	n, err := app.users.DeleteExpiredSessions()
+	if err != nil {
+		app.errorLog.Printf("deleting expired sessions: %v", err)
+		return
+	}
+	if n > 0 {
+		app.infoLog.Printf("worker=reaper expired_sessions=%d", n)
+	}
+
+	//the emailed tokens that were never used
+	n, err = app.users.DeleteExpiredTokens()
+	if err != nil {
+		app.errorLog.Printf("deleting expired tokens: %v", err)
+		return
+	}
+	if n > 0 {
+		app.infoLog.Printf("worker=reaper tokens=%d", n)
+	}
+
+	//and the records of the sessions that are over, now that they are gone from the sessions table
+	n, err = app.users.DeleteStaleSessions()
+	if err != nil {
+		app.errorLog.Printf("deleting stale sessions: %v", err)
+		return
+	}
+	if n > 0 {
+		app.infoLog.Printf("worker=reaper sessions=%d", n)
+	}
+}
@@ -0,0 +1,365 @@
+package diff
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Line based diff using the Myers algorithm, see "An O(ND) Difference Algorithm and Its Variations" (1986).
+
+type Op int
+
+const (
+	Equal Op = iota
+	Insert
+	Delete
+)
+
+// Line is one line of a diff. A and B are its line numbers in the old and the new text, counting from 1, and 0 in the
+// text it is not part of
+type Line struct {
+	Op   Op
+	Text string
+	A    int
+	B    int
+}
+
+// Hunk is a group of changes with the lines of context around them, the same as a @@ section of a unified diff
+type Hunk struct {
+	AStart, ALines int
+	BStart, BLines int
+	Lines          []Line
+}
+
+// Row is a line of a side by side diff, Left or Right is nil when the line only exists on one side
+type Row struct {
+	Left  *Line
+	Right *Line
+}
+
+// MaxEdits bounds the work done by Lines, texts that differ by more lines than this are reported as one deletion of
+// the old text followed by one insertion of the new text
+const MaxEdits = 4000
+
+// Lines compares two texts line by line and returns every line of both, in order
+func Lines(a, b string) []Line {
+	x, y := split(a), split(b)
+
+	//the common beginning and end are left out of the search, this is where most edits are cheap
+	prefix := 0
+	for prefix < len(x) && prefix < len(y) && x[prefix] == y[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(x)-prefix && suffix < len(y)-prefix && x[len(x)-1-suffix] == y[len(y)-1-suffix] {
+		suffix++
+	}
+
+	lines := make([]Line, 0, len(x)+len(y))
+	for i := 0; i < prefix; i++ {
+		lines = append(lines, Line{Op: Equal, Text: x[i], A: i + 1, B: i + 1})
+	}
+	lines = append(lines, myers(x[prefix:len(x)-suffix], y[prefix:len(y)-suffix], prefix, prefix)...)
+	for i := 0; i < suffix; i++ {
+		ai, bi := len(x)-suffix+i, len(y)-suffix+i
+		lines = append(lines, Line{Op: Equal, Text: x[ai], A: ai + 1, B: bi + 1})
+	}
+	return lines
+}
+
+// split a text into lines, a final newline doesn't start another line
+func split(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(strings.ReplaceAll(text, "\r\n", "\n"), "\n"), "\n")
+}
+
+// myers returns the shortest edit script turning x into y as a list of lines. aOffset and bOffset are the number of
+// lines before x and y, for the line numbers. It uses the linear space variant of the paper: the middle snake of the
+// edit script is found by searching from both ends at once, then the parts before and after it are compared the same
+// way, so only two arrays of diagonals are kept however different the texts are
+func myers(x, y []string, aOffset, bOffset int) []Line {
+	n, m := len(x), len(y)
+	if n > 0 && m > 0 {
+		//the diagonals of the widest search are -(n+m+1)/2-1 to (n+m+1)/2+1
+		size := (n+m+1)/2 + 2
+		d := &differ{x: x, y: y, aOffset: aOffset, bOffset: bOffset, offset: size}
+		d.forward = make([]int, 2*size+1)
+		d.backward = make([]int, 2*size+1)
+		d.lines = make([]Line, 0, n+m)
+		//the first search is the widest, when it gives up the texts are too different
+		if d.compare(0, n, 0, m, (MaxEdits+1)/2) {
+			return d.lines
+		}
+	}
+
+	//one side is empty or the texts are too different, everything old is removed and everything new is added
+	lines := make([]Line, 0, n+m)
+	for i := range x {
+		lines = append(lines, Line{Op: Delete, Text: x[i], A: aOffset + i + 1})
+	}
+	for j := range y {
+		lines = append(lines, Line{Op: Insert, Text: y[j], B: bOffset + j + 1})
+	}
+	return lines
+}
+
+// differ holds the state of one comparison, the arrays of diagonals are shared by all the searches
+type differ struct {
+	x, y             []string
+	aOffset, bOffset int
+	//forward[k+offset] is the furthest x reached on diagonal k from the start, backward[k+offset] the furthest
+	//distance from the end reached on diagonal k of the reversed texts
+	forward, backward []int
+	offset            int
+	lines             []Line
+}
+
+// compare appends the edit script turning x[a0:a1] into y[b0:b1]. The middle snake search gives up after limit rounds,
+// 0 for no limit, and compare then returns false without appending anything
+func (d *differ) compare(a0, a1, b0, b1 int, limit int) bool {
+	//the common beginning and end are copied as they are
+	prefix := 0
+	for a0+prefix < a1 && b0+prefix < b1 && d.x[a0+prefix] == d.y[b0+prefix] {
+		prefix++
+	}
+	suffix := 0
+	for a0+prefix < a1-suffix && b0+prefix < b1-suffix && d.x[a1-1-suffix] == d.y[b1-1-suffix] {
+		suffix++
+	}
+
+	var xs, ys, xe, ye int
+	split := a0+prefix < a1-suffix && b0+prefix < b1-suffix
+	if split {
+		var ok bool
+		xs, ys, xe, ye, ok = d.middleSnake(a0+prefix, a1-suffix, b0+prefix, b1-suffix, limit)
+		if !ok {
+			return false
+		}
+	}
+
+	d.equal(a0, b0, prefix)
+	a0, b0, a1, b1 = a0+prefix, b0+prefix, a1-suffix, b1-suffix
+	if split {
+		//both halves are smaller than what was searched, they can't give up
+		d.compare(a0, xs, b0, ys, 0)
+		d.equal(xs, ys, xe-xs)
+		d.compare(xe, a1, ye, b1, 0)
+	} else {
+		for i := a0; i < a1; i++ {
+			d.lines = append(d.lines, Line{Op: Delete, Text: d.x[i], A: d.aOffset + i + 1})
+		}
+		for j := b0; j < b1; j++ {
+			d.lines = append(d.lines, Line{Op: Insert, Text: d.y[j], B: d.bOffset + j + 1})
+		}
+	}
+	d.equal(a1, b1, suffix)
+	return true
+}
+
+// equal appends the count lines starting at x[i] and y[j], which are the same
+func (d *differ) equal(i, j, count int) {
+	for c := 0; c < count; c++ {
+		d.lines = append(d.lines, Line{Op: Equal, Text: d.x[i+c], A: d.aOffset + i + c + 1, B: d.bOffset + j + c + 1})
+	}
+}
+
+// middleSnake finds the snake in the middle of a shortest edit script turning x[a0:a1] into y[b0:b1], both non empty,
+// and returns where it starts and ends. The snake may be empty. ok is false when limit rounds weren't enough
+func (d *differ) middleSnake(a0, a1, b0, b1 int, limit int) (xs, ys, xe, ye int, ok bool) {
+	n, m := a1-a0, b1-b0
+	delta := n - m
+	odd := delta%2 != 0
+	o := d.offset
+	d.forward[o+1] = 0
+	d.backward[o+1] = 0
+
+	for r := 0; r <= (n+m+1)/2; r++ {
+		if limit > 0 && r > limit {
+			return 0, 0, 0, 0, false
+		}
+
+		//one more edit from the start, the backward diagonal delta-k is the same line of the grid
+		for k := -r; k <= r; k += 2 {
+			var i int
+			if k == -r || (k != r && d.forward[o+k-1] < d.forward[o+k+1]) {
+				i = d.forward[o+k+1] //down: insert a line of y
+			} else {
+				i = d.forward[o+k-1] + 1 //right: delete a line of x
+			}
+			j := i - k
+			si, sj := i, j
+			for i < n && j < m && d.x[a0+i] == d.y[b0+j] {
+				i++
+				j++
+			}
+			d.forward[o+k] = i
+			if kb := delta - k; odd && kb >= -(r-1) && kb <= r-1 && i+d.backward[o+kb] >= n {
+				return a0 + si, b0 + sj, a0 + i, b0 + j, true
+			}
+		}
+
+		//and one more from the end, walking the texts backwards
+		for k := -r; k <= r; k += 2 {
+			var u int
+			if k == -r || (k != r && d.backward[o+k-1] < d.backward[o+k+1]) {
+				u = d.backward[o+k+1]
+			} else {
+				u = d.backward[o+k-1] + 1
+			}
+			v := u - k
+			su, sv := u, v
+			for u < n && v < m && d.x[a1-1-u] == d.y[b1-1-v] {
+				u++
+				v++
+			}
+			d.backward[o+k] = u
+			if kf := delta - k; !odd && kf >= -r && kf <= r && u+d.forward[o+kf] >= n {
+				return a1 - u, b1 - v, a1 - su, b1 - sv, true
+			}
+		}
+	}
+	//the searches always meet before the end
+	panic("diff: middle snake not found")
+}
+
+// Changed reports whether any line was inserted or deleted
+func Changed(lines []Line) bool {
+	for _, l := range lines {
+		if l.Op != Equal {
+			return true
+		}
+	}
+	return false
+}
+
+// Hunks groups the changed lines with up to context unchanged lines around them, changes closer than twice the
+// context end up in the same hunk
+func Hunks(lines []Line, context int) []Hunk {
+	hunks := []Hunk{}
+	for start := 0; start < len(lines); {
+		//find the next change
+		for start < len(lines) && lines[start].Op == Equal {
+			start++
+		}
+		if start == len(lines) {
+			break
+		}
+
+		//extend the hunk until there are more than 2*context unchanged lines in a row
+		end := start
+		for end < len(lines) {
+			if lines[end].Op != Equal {
+				end++
+				continue
+			}
+			run := end
+			for run < len(lines) && lines[run].Op == Equal {
+				run++
+			}
+			if run == len(lines) || run-end > 2*context {
+				break
+			}
+			end = run
+		}
+
+		from := max(start-context, 0)
+		to := min(end+context, len(lines))
+		hunks = append(hunks, newHunk(lines[from:to]))
+		start = to
+	}
+	return hunks
+}
+
+// newHunk counts the lines of each side. A side without lines starts at 0, which is only right when that text is empty,
+// so hunks are expected to have some context
+func newHunk(lines []Line) Hunk {
+	h := Hunk{Lines: lines}
+	for _, l := range lines {
+		if l.Op != Insert {
+			if h.AStart == 0 {
+				h.AStart = l.A
+			}
+			h.ALines++
+		}
+		if l.Op != Delete {
+			if h.BStart == 0 {
+				h.BStart = l.B
+			}
+			h.BLines++
+		}
+	}
+	return h
+}
+
+// Header returns the @@ line of a hunk
+func (h Hunk) Header() string {
+	return fmt.Sprintf("@@ -%s +%s @@", span(h.AStart, h.ALines), span(h.BStart, h.BLines))
+}
+
+func span(start, lines int) string {
+	if lines == 1 {
+		return fmt.Sprint(start)
+	}
+	return fmt.Sprintf("%d,%d", start, lines)
+}
+
+// Unified writes the hunks as a unified diff, as read by patch and git apply
+func Unified(aName, bName string, hunks []Hunk) string {
+	if len(hunks) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "--- %s\n+++ %s\n", aName, bName)
+	for _, h := range hunks {
+		b.WriteString(h.Header())
+		b.WriteByte('\n')
+		for _, l := range h.Lines {
+			switch l.Op {
+			case Equal:
+				b.WriteByte(' ')
+			case Insert:
+				b.WriteByte('+')
+			case Delete:
+				b.WriteByte('-')
+			}
+			b.WriteString(l.Text)
+			b.WriteByte('\n')
+		}
+	}
+	return b.String()
+}
+
+// SideBySide pairs the lines of a hunk for a two column view, the deleted lines of a change are shown next to the
+// lines inserted in their place
+func SideBySide(h Hunk) []Row {
+	rows := []Row{}
+	lines := h.Lines
+	for i := 0; i < len(lines); {
+		if lines[i].Op == Equal {
+			rows = append(rows, Row{Left: &lines[i], Right: &lines[i]})
+			i++
+			continue
+		}
+		deleted, inserted := []*Line{}, []*Line{}
+		for ; i < len(lines) && lines[i].Op != Equal; i++ {
+			if lines[i].Op == Delete {
+				deleted = append(deleted, &lines[i])
+			} else {
+				inserted = append(inserted, &lines[i])
+			}
+		}
+		for n := 0; n < len(deleted) || n < len(inserted); n++ {
+			row := Row{}
+			if n < len(deleted) {
+				row.Left = deleted[n]
+			}
+			if n < len(inserted) {
+				row.Right = inserted[n]
+			}
+			rows = append(rows, row)
+		}
+	}
+	return rows
+}
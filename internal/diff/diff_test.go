@@ -0,0 +1,263 @@
+package diff
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// check that the lines of a diff rebuild both texts with the right line numbers, and that there are as few changes as
+// the longest common subsequence allows
+func checkLines(t *testing.T, a, b string, lines []Line) {
+	t.Helper()
+
+	var gotA, gotB []string
+	edits := 0
+	for _, l := range lines {
+		if l.Op != Insert {
+			gotA = append(gotA, l.Text)
+			if l.A != len(gotA) {
+				t.Fatalf("line %q has A = %d; want %d", l.Text, l.A, len(gotA))
+			}
+		}
+		if l.Op != Delete {
+			gotB = append(gotB, l.Text)
+			if l.B != len(gotB) {
+				t.Fatalf("line %q has B = %d; want %d", l.Text, l.B, len(gotB))
+			}
+		}
+		if l.Op != Equal {
+			edits++
+		}
+	}
+
+	x, y := split(a), split(b)
+	if strings.Join(gotA, "\n") != strings.Join(x, "\n") || len(gotA) != len(x) {
+		t.Fatalf("old side is %q; want %q", gotA, x)
+	}
+	if strings.Join(gotB, "\n") != strings.Join(y, "\n") || len(gotB) != len(y) {
+		t.Fatalf("new side is %q; want %q", gotB, y)
+	}
+	if want := len(x) + len(y) - 2*lcs(x, y); edits != want {
+		t.Fatalf("got %d edits; want %d", edits, want)
+	}
+}
+
+// lcs returns the length of the longest common subsequence, the slow way
+func lcs(x, y []string) int {
+	prev := make([]int, len(y)+1)
+	cur := make([]int, len(y)+1)
+	for i := range x {
+		for j := range y {
+			if x[i] == y[j] {
+				cur[j+1] = prev[j] + 1
+			} else {
+				cur[j+1] = max(cur[j], prev[j+1])
+			}
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(y)]
+}
+
+func TestLines(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+	}{
+		{name: "Equal", a: "a\nb\nc\n", b: "a\nb\nc\n"},
+		{name: "Both empty", a: "", b: ""},
+		{name: "Old empty", a: "", b: "a\nb\n"},
+		{name: "New empty", a: "a\nb\n", b: ""},
+		{name: "Insert", a: "a\nc\n", b: "a\nb\nc\n"},
+		{name: "Delete", a: "a\nb\nc\n", b: "a\nc\n"},
+		{name: "Replace", a: "a\nb\nc\n", b: "a\nx\nc\n"},
+		{name: "Nothing in common", a: "a\nb\n", b: "c\nd\ne\n"},
+		{name: "Paper example", a: "a\nb\nc\na\nb\nb\na\n", b: "c\nb\na\nb\na\nc\n"},
+		{name: "No final newline", a: "a\nb", b: "a\nb\n"},
+		{name: "Windows newlines", a: "a\r\nb\r\n", b: "a\nc\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkLines(t, tt.a, tt.b, Lines(tt.a, tt.b))
+		})
+	}
+}
+
+func TestLinesRandom(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	text := func() string {
+		var b strings.Builder
+		for i := r.Intn(40); i > 0; i-- {
+			//a small alphabet makes lots of matching lines
+			fmt.Fprintf(&b, "%c\n", 'a'+r.Intn(4))
+		}
+		return b.String()
+	}
+
+	for i := 0; i < 2000; i++ {
+		a, b := text(), text()
+		checkLines(t, a, b, Lines(a, b))
+	}
+}
+
+// texts differing by more than MaxEdits lines come out as everything deleted, then everything inserted
+func TestLinesMaxEdits(t *testing.T) {
+	var a, b strings.Builder
+	for i := 0; i < MaxEdits; i++ {
+		fmt.Fprintf(&a, "a%d\n", i)
+		fmt.Fprintf(&b, "b%d\n", i)
+	}
+
+	lines := Lines(a.String(), b.String())
+	if len(lines) != 2*MaxEdits {
+		t.Fatalf("got %d lines; want %d", len(lines), 2*MaxEdits)
+	}
+	for i, l := range lines {
+		want := Delete
+		if i >= MaxEdits {
+			want = Insert
+		}
+		if l.Op != want {
+			t.Fatalf("line %d is %v; want %v", i, l.Op, want)
+		}
+	}
+}
+
+// a few changes spread over long texts
+func TestLinesLong(t *testing.T) {
+	var a, b strings.Builder
+	for i := 0; i < 20000; i++ {
+		fmt.Fprintf(&a, "line %d\n", i)
+		if i%1000 == 0 {
+			fmt.Fprintf(&b, "changed %d\n", i)
+		} else {
+			fmt.Fprintf(&b, "line %d\n", i)
+		}
+	}
+
+	lines := Lines(a.String(), b.String())
+	edits := 0
+	for _, l := range lines {
+		if l.Op != Equal {
+			edits++
+		}
+	}
+	if edits != 40 {
+		t.Errorf("got %d edits; want 40", edits)
+	}
+}
+
+func TestHunks(t *testing.T) {
+	tests := []struct {
+		name    string
+		a, b    string
+		context int
+		want    []string
+	}{
+		{
+			name:    "Equal",
+			a:       "a\nb\n",
+			b:       "a\nb\n",
+			context: 3,
+			want:    []string{},
+		},
+		{
+			name:    "One change",
+			a:       "1\n2\n3\n4\n5\n6\n7\n8\n9\n",
+			b:       "1\n2\n3\n4\nfive\n6\n7\n8\n9\n",
+			context: 3,
+			want:    []string{"@@ -2,7 +2,7 @@"},
+		},
+		{
+			name:    "Two hunks",
+			a:       "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n",
+			b:       "one\n2\n3\n4\n5\n6\n7\n8\n9\nten\n",
+			context: 2,
+			want:    []string{"@@ -1,3 +1,3 @@", "@@ -8,3 +8,3 @@"},
+		},
+		{
+			name:    "Merged hunks",
+			a:       "1\n2\n3\n4\n5\n",
+			b:       "one\n2\n3\n4\nfive\n",
+			context: 2,
+			want:    []string{"@@ -1,5 +1,5 @@"},
+		},
+		{
+			name:    "Single line",
+			a:       "a\n",
+			b:       "b\n",
+			context: 3,
+			want:    []string{"@@ -1 +1 @@"},
+		},
+		{
+			name:    "Old empty",
+			a:       "",
+			b:       "a\nb\n",
+			context: 3,
+			want:    []string{"@@ -0,0 +1,2 @@"},
+		},
+		{
+			name:    "New empty",
+			a:       "a\nb\n",
+			b:       "",
+			context: 3,
+			want:    []string{"@@ -1,2 +0,0 @@"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hunks := Hunks(Lines(tt.a, tt.b), tt.context)
+			got := []string{}
+			for _, h := range hunks {
+				got = append(got, h.Header())
+			}
+			if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+				t.Errorf("got %q; want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnified(t *testing.T) {
+	a := "a\nb\nc\n"
+	b := "a\nx\nc\nd\n"
+
+	got := Unified("a/main.go", "b/main.go", Hunks(Lines(a, b), 3))
+	want := "--- a/main.go\n+++ b/main.go\n@@ -1,3 +1,4 @@\n a\n-b\n+x\n c\n+d\n"
+	if got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+
+	if got := Unified("a", "b", Hunks(Lines(a, a), 3)); got != "" {
+		t.Errorf("got %q for equal texts; want an empty diff", got)
+	}
+}
+
+func TestSideBySide(t *testing.T) {
+	hunks := Hunks(Lines("a\nb\nc\n", "a\nx\ny\nc\n"), 3)
+	if len(hunks) != 1 {
+		t.Fatalf("got %d hunks; want 1", len(hunks))
+	}
+
+	rows := SideBySide(hunks[0])
+	want := [][2]string{{"a", "a"}, {"b", "x"}, {"", "y"}, {"c", "c"}}
+	if len(rows) != len(want) {
+		t.Fatalf("got %d rows; want %d", len(rows), len(want))
+	}
+	for i, row := range rows {
+		var left, right string
+		if row.Left != nil {
+			left = row.Left.Text
+		}
+		if row.Right != nil {
+			right = row.Right.Text
+		}
+		if left != want[i][0] || right != want[i][1] {
+			t.Errorf("row %d is %q | %q; want %q | %q", i, left, right, want[i][0], want[i][1])
+		}
+	}
+}
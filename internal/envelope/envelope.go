@@ -0,0 +1,241 @@
+package envelope
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Envelope encryption: every value is encrypted with its own random data key using AES-256-GCM, and the data key is
+// encrypted (wrapped) with the master key. Rotating the master key then only means re-wrapping the data keys.
+//
+// A sealed value is a single string that can be stored in a text column:
+//
+//	enc:v1:<master key id>:<wrapped data key>:<nonce and ciphertext>
+//
+// the last two parts are base64 encoded, and the key id is derived from the master key itself so it never has to be
+// configured.
+
+const prefix = "enc:v1:"
+
+var (
+	ErrUnknownKey = errors.New("envelope: value sealed with an unknown master key")
+	ErrMalformed  = errors.New("envelope: malformed sealed value")
+)
+
+// Keyring holds the master key used to seal new values, plus older master keys that can still open values, which is
+// only needed while the keys are being rotated
+type Keyring struct {
+	currentID string
+	keys      map[string]cipher.AEAD
+}
+
+// NewKeyring creates a keyring from 32 byte master keys, the first one seals new values
+func NewKeyring(current []byte, old ...[]byte) (*Keyring, error) {
+	k := &Keyring{keys: map[string]cipher.AEAD{}}
+	for i, key := range append([][]byte{current}, old...) {
+		aead, err := newAEAD(key)
+		if err != nil {
+			return nil, err
+		}
+		id := keyID(key)
+		if i == 0 {
+			k.currentID = id
+		}
+		k.keys[id] = aead
+	}
+	return k, nil
+}
+
+// LoadKey reads a base64 encoded master key from a file, or from the value of an environment variable if no file is
+// given. It returns nil when neither is set, which leaves encryption disabled
+func LoadKey(path string, env string) ([]byte, error) {
+	encoded := env
+	if path != "" {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		encoded = string(b)
+	}
+	encoded = strings.TrimSpace(encoded)
+	if encoded == "" {
+		return nil, nil
+	}
+
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("envelope: the master key must be base64 encoded: %w", err)
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("envelope: the master key must be 32 bytes long, got %d", len(key))
+	}
+	return key, nil
+}
+
+// LoadKeyring loads the current master key and, while the keys are being rotated, the previous one with LoadKey. It
+// returns nil when no current key is set
+func LoadKeyring(path string, env string, oldPath string, oldEnv string) (*Keyring, error) {
+	current, err := LoadKey(path, env)
+	if err != nil || current == nil {
+		return nil, err
+	}
+	old, err := LoadKey(oldPath, oldEnv)
+	if err != nil {
+		return nil, err
+	}
+	if old == nil {
+		return NewKeyring(current)
+	}
+	return NewKeyring(current, old)
+}
+
+// CurrentKeyID returns the id of the master key that seals new values
+func (k *Keyring) CurrentKeyID() string {
+	return k.currentID
+}
+
+// IsSealed reports whether a value was produced by Seal, anything else is treated as plain text
+func IsSealed(value string) bool {
+	return strings.HasPrefix(value, prefix)
+}
+
+// Seal encrypts a value with a new data key wrapped by the current master key
+func (k *Keyring) Seal(plaintext string) (string, error) {
+	dataKey := make([]byte, 32)
+	_, err := rand.Read(dataKey)
+	if err != nil {
+		return "", err
+	}
+	dataAEAD, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+
+	wrapped, err := seal(k.keys[k.currentID], dataKey, []byte(k.currentID))
+	if err != nil {
+		return "", err
+	}
+	ciphertext, err := seal(dataAEAD, []byte(plaintext), nil)
+	if err != nil {
+		return "", err
+	}
+
+	return prefix + k.currentID + ":" + base64.StdEncoding.EncodeToString(wrapped) + ":" + base64.StdEncoding.EncodeToString(ciphertext), nil
+}
+
+// Open decrypts a sealed value, values that aren't sealed are returned unchanged so rows written before encryption
+// was enabled can still be read
+func (k *Keyring) Open(value string) (string, error) {
+	if !IsSealed(value) {
+		return value, nil
+	}
+	id, wrapped, ciphertext, err := split(value)
+	if err != nil {
+		return "", err
+	}
+	dataKey, err := k.unwrap(id, wrapped)
+	if err != nil {
+		return "", err
+	}
+	dataAEAD, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := open(dataAEAD, ciphertext, nil)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// Rewrap makes sure a value is sealed with the current master key. The data key of a value sealed with an older master
+// key is re-wrapped without touching the ciphertext, and plain text is sealed. changed is false if there was nothing
+// to do
+func (k *Keyring) Rewrap(value string) (result string, changed bool, err error) {
+	if !IsSealed(value) {
+		result, err = k.Seal(value)
+		return result, err == nil, err
+	}
+
+	id, wrapped, ciphertext, err := split(value)
+	if err != nil {
+		return "", false, err
+	}
+	if id == k.currentID {
+		return value, false, nil
+	}
+	dataKey, err := k.unwrap(id, wrapped)
+	if err != nil {
+		return "", false, err
+	}
+	wrapped, err = seal(k.keys[k.currentID], dataKey, []byte(k.currentID))
+	if err != nil {
+		return "", false, err
+	}
+	return prefix + k.currentID + ":" + base64.StdEncoding.EncodeToString(wrapped) + ":" + base64.StdEncoding.EncodeToString(ciphertext), true, nil
+}
+
+func (k *Keyring) unwrap(id string, wrapped []byte) ([]byte, error) {
+	master, ok := k.keys[id]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	return open(master, wrapped, []byte(id))
+}
+
+// split returns the key id, wrapped data key and ciphertext of a sealed value
+func split(value string) (string, []byte, []byte, error) {
+	parts := strings.Split(strings.TrimPrefix(value, prefix), ":")
+	if len(parts) != 3 {
+		return "", nil, nil, ErrMalformed
+	}
+	wrapped, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", nil, nil, ErrMalformed
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", nil, nil, ErrMalformed
+	}
+	return parts[0], wrapped, ciphertext, nil
+}
+
+// keyID identifies a master key by the first bytes of its hash, which reveals nothing about the key
+func keyID(key []byte) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:4])
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts with a random nonce, which is prepended to the ciphertext
+func seal(aead cipher.AEAD, plaintext []byte, additionalData []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func open(aead cipher.AEAD, ciphertext []byte, additionalData []byte) ([]byte, error) {
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, ErrMalformed
+	}
+	nonce, ciphertext := ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, additionalData)
+}
@@ -0,0 +1,347 @@
+package envelope
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newKey returns a 32 byte master key made of a repeated byte, so the tests are reproducible
+func newKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, 32)
+}
+
+func newTestKeyring(t *testing.T, current []byte, old ...[]byte) *Keyring {
+	t.Helper()
+	k, err := NewKeyring(current, old...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+// tamper decodes one of the base64 parts of a sealed value, flips a bit of byte i (counting from the end when
+// negative) and encodes it again
+func tamper(t *testing.T, sealed string, part int, i int) string {
+	t.Helper()
+	parts := strings.Split(strings.TrimPrefix(sealed, prefix), ":")
+	b, err := base64.StdEncoding.DecodeString(parts[part])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i < 0 {
+		i += len(b)
+	}
+	b[i] ^= 0x01
+	parts[part] = base64.StdEncoding.EncodeToString(b)
+	return prefix + strings.Join(parts, ":")
+}
+
+func TestSealOpen(t *testing.T) {
+	k := newTestKeyring(t, newKey(1))
+
+	tests := []struct {
+		name      string
+		plaintext string
+	}{
+		{name: "Empty", plaintext: ""},
+		{name: "Text", plaintext: "fmt.Println(\"hello\")"},
+		{name: "Multi-line", plaintext: "line 1\nline 2\r\n\ttabbed\n"},
+		{name: "Unicode", plaintext: "日本語 ʕ •ᴥ•ʔ"},
+		{name: "Looks sealed", plaintext: "enc:v1:not:really:sealed"},
+		{name: "Long", plaintext: strings.Repeat("x", 1<<20)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sealed, err := k.Seal(tt.plaintext)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !IsSealed(sealed) {
+				t.Fatalf("sealed value %q doesn't start with %q", sealed, prefix)
+			}
+			if !strings.HasPrefix(sealed, prefix+k.CurrentKeyID()+":") {
+				t.Errorf("sealed value doesn't name the current key %s", k.CurrentKeyID())
+			}
+			if tt.plaintext != "" && strings.Contains(sealed, tt.plaintext) {
+				t.Error("sealed value contains the plain text")
+			}
+
+			got, err := k.Open(sealed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.plaintext {
+				t.Errorf("got %q; want %q", got, tt.plaintext)
+			}
+		})
+	}
+}
+
+// every value gets its own data key and nonces, sealing the same text twice gives different values
+func TestSealRandomized(t *testing.T) {
+	k := newTestKeyring(t, newKey(1))
+
+	a, err := k.Seal("same")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := k.Seal("same")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a == b {
+		t.Error("sealing the same text twice gave the same value")
+	}
+}
+
+func TestOpenInvalid(t *testing.T) {
+	k := newTestKeyring(t, newKey(1))
+	sealed, err := k.Seal("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(strings.TrimPrefix(sealed, prefix), ":")
+	id, wrapped, ciphertext := parts[0], parts[1], parts[2]
+
+	tests := []struct {
+		name    string
+		value   string
+		wantErr error //nil for any error
+	}{
+		{name: "Only the prefix", value: prefix, wantErr: ErrMalformed},
+		{name: "Missing parts", value: prefix + id + ":" + wrapped, wantErr: ErrMalformed},
+		{name: "Extra part", value: sealed + ":extra", wantErr: ErrMalformed},
+		{name: "Wrapped key not base64", value: prefix + id + ":!!!:" + ciphertext, wantErr: ErrMalformed},
+		{name: "Ciphertext not base64", value: prefix + id + ":" + wrapped + ":!!!", wantErr: ErrMalformed},
+		{name: "Ciphertext shorter than a nonce", value: prefix + id + ":" + wrapped + ":AAAA", wantErr: ErrMalformed},
+		{name: "Wrapped key shorter than a nonce", value: prefix + id + ":AAAA:" + ciphertext, wantErr: ErrMalformed},
+		{name: "Truncated ciphertext", value: sealed[:len(sealed)-8]},
+		{name: "Unknown key id", value: prefix + "00000000:" + wrapped + ":" + ciphertext, wantErr: ErrUnknownKey},
+		{name: "Tampered tag", value: tamper(t, sealed, 2, -1)},
+		{name: "Tampered nonce", value: tamper(t, sealed, 2, 0)},
+		{name: "Tampered ciphertext", value: tamper(t, sealed, 2, 12)},
+		{name: "Tampered wrapped key", value: tamper(t, sealed, 1, -1)},
+		{name: "Tampered wrapped key nonce", value: tamper(t, sealed, 1, 0)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := k.Open(tt.value)
+			if err == nil {
+				t.Fatalf("got %q and no error", got)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("got error %v; want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// values without the v1 prefix are plain text written before encryption was enabled, including ones that only look
+// like a sealed value of another version
+func TestOpenPlainText(t *testing.T) {
+	k := newTestKeyring(t, newKey(1))
+
+	for _, value := range []string{"", "hello", "enc:v2:abcd:AAAA:AAAA", "enc:v1", "ENC:V1:abcd:AAAA:AAAA"} {
+		if IsSealed(value) {
+			t.Errorf("IsSealed(%q) = true", value)
+		}
+		got, err := k.Open(value)
+		if err != nil {
+			t.Errorf("Open(%q): %v", value, err)
+		}
+		if got != value {
+			t.Errorf("Open(%q) = %q; want it unchanged", value, got)
+		}
+	}
+}
+
+// the id of the key a value was sealed with is covered by the wrapping, another key id can't be swapped in
+func TestOpenSwappedKeyID(t *testing.T) {
+	k := newTestKeyring(t, newKey(1), newKey(2))
+	old := newTestKeyring(t, newKey(2))
+
+	sealed, err := old.Seal("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	swapped := strings.Replace(sealed, old.CurrentKeyID(), k.CurrentKeyID(), 1)
+	if _, err := k.Open(swapped); err == nil {
+		t.Error("a value opened with the key id of another key")
+	}
+}
+
+func TestRotation(t *testing.T) {
+	oldMaster, newMaster := newKey(1), newKey(2)
+	before := newTestKeyring(t, oldMaster)
+	during := newTestKeyring(t, newMaster, oldMaster)
+	after := newTestKeyring(t, newMaster)
+
+	sealed, err := before.Seal("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//the new key can't open the old value on its own
+	_, err = after.Open(sealed)
+	if !errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("got error %v; want %v", err, ErrUnknownKey)
+	}
+
+	//during the rotation both keys open it
+	got, err := during.Open(sealed)
+	if err != nil || got != "secret" {
+		t.Fatalf("got %q, %v; want %q", got, err, "secret")
+	}
+
+	rewrapped, changed, err := during.Rewrap(sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !changed {
+		t.Fatal("Rewrap reported no change for a value sealed with the old key")
+	}
+	//only the data key is wrapped again, the content keeps its ciphertext
+	if a, b := strings.Split(sealed, ":")[4], strings.Split(rewrapped, ":")[4]; a != b {
+		t.Error("Rewrap changed the ciphertext of the content")
+	}
+
+	//once rewrapped the old key isn't needed anymore
+	got, err = after.Open(rewrapped)
+	if err != nil || got != "secret" {
+		t.Fatalf("got %q, %v; want %q", got, err, "secret")
+	}
+	_, err = before.Open(rewrapped)
+	if !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("got error %v from the old key; want %v", err, ErrUnknownKey)
+	}
+
+	//rewrapping again does nothing
+	again, changed, err := during.Rewrap(rewrapped)
+	if err != nil || changed || again != rewrapped {
+		t.Errorf("got %q, %t, %v; want the value unchanged", again, changed, err)
+	}
+}
+
+func TestRewrap(t *testing.T) {
+	k := newTestKeyring(t, newKey(1))
+	sealed, err := k.Seal("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		value       string
+		wantChanged bool
+		wantErr     error
+	}{
+		{name: "Plain text", value: "secret", wantChanged: true},
+		{name: "Current key", value: sealed, wantChanged: false},
+		{name: "Unknown key", value: prefix + "00000000:" + strings.SplitN(strings.TrimPrefix(sealed, prefix), ":", 2)[1], wantErr: ErrUnknownKey},
+		{name: "Malformed", value: prefix + "abcd", wantErr: ErrMalformed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, changed, err := k.Rewrap(tt.value)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("got error %v; want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if changed != tt.wantChanged {
+				t.Errorf("got changed %t; want %t", changed, tt.wantChanged)
+			}
+			plaintext, err := k.Open(got)
+			if err != nil || plaintext != "secret" {
+				t.Errorf("got %q, %v after Rewrap; want %q", plaintext, err, "secret")
+			}
+		})
+	}
+}
+
+func TestNewKeyring(t *testing.T) {
+	tests := []struct {
+		name    string
+		current []byte
+		old     [][]byte
+		wantErr bool
+	}{
+		{name: "One key", current: newKey(1)},
+		{name: "With an old key", current: newKey(1), old: [][]byte{newKey(2)}},
+		{name: "Short key", current: make([]byte, 31), wantErr: true},
+		{name: "Short old key", current: newKey(1), old: [][]byte{make([]byte, 7)}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k, err := NewKeyring(tt.current, tt.old...)
+			if tt.wantErr {
+				if err == nil {
+					t.Error("got no error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if k.CurrentKeyID() != keyID(tt.current) {
+				t.Errorf("got current key %s; want %s", k.CurrentKeyID(), keyID(tt.current))
+			}
+		})
+	}
+}
+
+func TestLoadKey(t *testing.T) {
+	valid := base64.StdEncoding.EncodeToString(newKey(1))
+	dir := t.TempDir()
+	file := filepath.Join(dir, "master.key")
+	err := os.WriteFile(file, []byte(valid+"\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		path    string
+		env     string
+		want    []byte
+		wantErr bool
+	}{
+		{name: "Nothing set", want: nil},
+		{name: "Environment", env: valid, want: newKey(1)},
+		{name: "File wins", path: file, env: base64.StdEncoding.EncodeToString(newKey(2)), want: newKey(1)},
+		{name: "Missing file", path: filepath.Join(dir, "missing"), wantErr: true},
+		{name: "Not base64", env: "not base64!", wantErr: true},
+		{name: "Wrong length", env: base64.StdEncoding.EncodeToString(make([]byte, 16)), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := LoadKey(tt.path, tt.env)
+			if tt.wantErr {
+				if err == nil {
+					t.Error("got no error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("got %x; want %x", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package mailer
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"mime"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Message is a plain text email to a single recipient
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends emails. SMTP delivers them, Dir and Log keep them on the machine for development
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// SMTP sends the emails through an SMTP server, with STARTTLS when the server offers it and PLAIN authentication when
+// a username is set
+type SMTP struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	Sender   string //the From address, for example "SnippetGo <no-reply@example.com>"
+}
+
+func (m *SMTP) Send(msg Message) error {
+	from, err := address(m.Sender)
+	if err != nil {
+		return err
+	}
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+	addr := net.JoinHostPort(m.Host, fmt.Sprint(m.Port))
+	return smtp.SendMail(addr, auth, from, []string{msg.To}, format(m.Sender, msg))
+}
+
+// Dir writes every email into a file of its own in a directory, which is created if needed
+type Dir struct {
+	Path   string
+	Sender string
+}
+
+func (m *Dir) Send(msg Message) error {
+	err := os.MkdirAll(m.Path, 0o700)
+	if err != nil {
+		return err
+	}
+	//the time keeps the files in the order they were sent, the random part keeps them apart
+	suffix := make([]byte, 4)
+	_, err = rand.Read(suffix)
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s-%s.eml", time.Now().UTC().Format("20060102T150405.000000"), hex.EncodeToString(suffix))
+	return os.WriteFile(filepath.Join(m.Path, name), format(m.Sender, msg), 0o600)
+}
+
+// Log writes every email to a logger, so the links they contain can be followed from the console
+type Log struct {
+	Logger *log.Logger
+	Sender string
+}
+
+func (m *Log) Send(msg Message) error {
+	m.Logger.Printf("email\n%s", strings.ReplaceAll(string(format(m.Sender, msg)), "\r\n", "\n"))
+	return nil
+}
+
+// address returns the bare address of a sender written as "Name <address>"
+func address(sender string) (string, error) {
+	if i := strings.LastIndex(sender, "<"); i >= 0 && strings.HasSuffix(sender, ">") {
+		sender = sender[i+1 : len(sender)-1]
+	}
+	if !strings.Contains(sender, "@") {
+		return "", fmt.Errorf("mailer: invalid sender %q", sender)
+	}
+	return sender, nil
+}
+
+// format returns the message with its headers, the way it is sent to an SMTP server. Line breaks are removed from the
+// header values so they can't add headers of their own
+func format(sender string, msg Message) []byte {
+	clean := strings.NewReplacer("\r", "", "\n", "")
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", clean.Replace(sender))
+	fmt.Fprintf(&b, "To: %s\r\n", clean.Replace(msg.To))
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", clean.Replace(msg.Subject)))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(strings.ReplaceAll(msg.Body, "\r\n", "\n"), "\n", "\r\n"))
+	return []byte(b.String())
+}
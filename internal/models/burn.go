@@ -0,0 +1,82 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Burn after reading snippets are flagged with a column on the snippets table. Once read, the row is deleted and only
+// its slug is kept in burned_snippets, so the share link can tell the snippet was burned rather than never existed:
+//
+//	ALTER TABLE snippets ADD burn_after_read BOOLEAN NOT NULL DEFAULT FALSE;
+//
+//	CREATE TABLE burned_snippets (
+//		slug CHAR(22) NOT NULL PRIMARY KEY,
+//		burned_at DATETIME NOT NULL
+//	);
+
+// Burn This will return a burn after reading snippet based on its share link and delete it in the same transaction,
+// so only one reader can ever get the content. The owner reading their own snippet doesn't burn it.
+func (m *SnippetModel) Burn(slug string, readerID int) (*Snippet, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	//FOR UPDATE makes a concurrent reader wait until this transaction is over, it then finds no row
+	stmt := `SELECT ` + snippetColumns + ` FROM snippets s JOIN users u ON u.id = s.user_id
+	WHERE s.expires > UTC_TIMESTAMP() AND s.deleted_at IS NULL AND s.burn_after_read AND s.slug = ? FOR UPDATE`
+	s, err := m.scanSnippet(tx.QueryRow(stmt, slug))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		}
+		return nil, err
+	}
+	err = m.loadTags([]*Snippet{s})
+	if err != nil {
+		return nil, err
+	}
+	err = m.loadFiles(s)
+	if err != nil {
+		return nil, err
+	}
+	err = m.loadOrigin(s)
+	if err != nil {
+		return nil, err
+	}
+	if s.UserID == readerID {
+		return s, nil
+	}
+
+	//revisions, files and tags go with the snippet through their foreign keys
+	_, err = tx.Exec("DELETE FROM snippets WHERE id = ?", s.rowID)
+	if err != nil {
+		return nil, err
+	}
+	_, err = tx.Exec("INSERT INTO burned_snippets (slug, burned_at) VALUES(?, UTC_TIMESTAMP())", slug)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Burned This will return when the snippet with the given share link was burned, or ErrNoRecord if it never was.
+func (m *SnippetModel) Burned(slug string) (time.Time, error) {
+	var burnedAt time.Time
+	err := m.DB.QueryRow("SELECT burned_at FROM burned_snippets WHERE slug = ?", slug).Scan(&burnedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return time.Time{}, ErrNoRecord
+		}
+		return time.Time{}, err
+	}
+	return burnedAt, nil
+}
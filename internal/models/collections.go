@@ -0,0 +1,211 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/go-sql-driver/mysql"
+	"time"
+)
+
+// Collections are folders owned by a user, they can be nested and every snippet is in at most one of them. A unique key
+// allows any number of NULLs, so the names are unique per parent_key, which is 0 for the top level collections:
+//
+//	CREATE TABLE collections (
+//		id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+//		user_id INTEGER NOT NULL,
+//		parent_id INTEGER NULL,
+//		parent_key INTEGER AS (COALESCE(parent_id, 0)) STORED,
+//		name VARCHAR(50) NOT NULL,
+//		created DATETIME NOT NULL,
+//		CONSTRAINT collections_uc_name UNIQUE (user_id, parent_key, name),
+//		CONSTRAINT fk_collections_user FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+//		CONSTRAINT fk_collections_parent FOREIGN KEY (parent_id) REFERENCES collections(id)
+//	);
+//
+//	ALTER TABLE snippets ADD collection_id INTEGER NULL;
+//	ALTER TABLE snippets ADD CONSTRAINT fk_snippets_collection FOREIGN KEY (collection_id) REFERENCES collections(id) ON DELETE SET NULL;
+type Collection struct {
+	ID       int
+	Name     string
+	ParentID int           //0 for a top level collection
+	Path     string        //the names of the parents and of the collection, separated by slashes
+	Depth    int           //0 for a top level collection
+	Children []*Collection //only filled in by Tree
+	Created  time.Time
+}
+
+// MaxCollectionDepth is the number of levels collections can be nested in
+const MaxCollectionDepth = 5
+
+var ErrDuplicateCollection = errors.New("models: duplicate collection name")
+
+type CollectionModel struct {
+	DB *sql.DB
+}
+
+// Insert This will add a collection for a user, parentID 0 adds it at the top level. The parent has to belong to the
+// same user.
+func (m *CollectionModel) Insert(userID int, parentID int, name string) (int, error) {
+	stmt := `INSERT INTO collections (user_id, parent_id, name, created)
+	SELECT ?, NULLIF(?, 0), ?, UTC_TIMESTAMP() FROM DUAL
+	WHERE ? = 0 OR EXISTS (SELECT 1 FROM collections WHERE id = ? AND user_id = ?)`
+
+	result, err := m.DB.Exec(stmt, userID, parentID, name, parentID, parentID, userID)
+	if err != nil {
+		var mySQLError *mysql.MySQLError
+		if errors.As(err, &mySQLError) && mySQLError.Number == 1062 {
+			return 0, ErrDuplicateCollection
+		}
+		return 0, err
+	}
+	err = expectRow(result)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	return int(id), nil
+}
+
+// Tree This will return the collections of a user as a tree, the top level ones in alphabetical order with their
+// children in the same order.
+func (m *CollectionModel) Tree(userID int) ([]*Collection, error) {
+	stmt := `SELECT id, name, COALESCE(parent_id, 0), created FROM collections WHERE user_id = ? ORDER BY name, id`
+	rows, err := m.DB.Query(stmt, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	all := []*Collection{}
+	byID := map[int]*Collection{}
+
+	for rows.Next() {
+		c := &Collection{Children: []*Collection{}}
+		err = rows.Scan(&c.ID, &c.Name, &c.ParentID, &c.Created)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, c)
+		byID[c.ID] = c
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	roots := []*Collection{}
+	for _, c := range all {
+		if parent, ok := byID[c.ParentID]; ok {
+			parent.Children = append(parent.Children, c)
+		} else {
+			roots = append(roots, c)
+		}
+	}
+	setPaths(roots, "", 0)
+	return roots, nil
+}
+
+// setPaths fills in the Path and Depth fields of a level of the tree and of everything below it
+func setPaths(collections []*Collection, prefix string, depth int) {
+	for _, c := range collections {
+		c.Path = prefix + c.Name
+		c.Depth = depth
+		setPaths(c.Children, c.Path+" / ", depth+1)
+	}
+}
+
+// Flatten returns every collection of a tree, each one followed by its children
+func Flatten(tree []*Collection) []*Collection {
+	flat := []*Collection{}
+	for _, c := range tree {
+		flat = append(flat, c)
+		flat = append(flat, Flatten(c.Children)...)
+	}
+	return flat
+}
+
+// Find returns the collection with the given id from a tree, nil if it isn't part of it
+func Find(tree []*Collection, id int) *Collection {
+	for _, c := range Flatten(tree) {
+		if c.ID == id {
+			return c
+		}
+	}
+	return nil
+}
+
+// Delete This will remove a collection of a user. Its snippets and the collections in it move up to its parent, nothing
+// else is deleted.
+func (m *CollectionModel) Delete(id int, userID int) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var parentID sql.NullInt64
+	err = tx.QueryRow(`SELECT parent_id FROM collections WHERE id = ? AND user_id = ? FOR UPDATE`, id, userID).Scan(&parentID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNoRecord
+		}
+		return err
+	}
+
+	_, err = tx.Exec(`UPDATE snippets SET collection_id = ? WHERE collection_id = ?`, parentID, id)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`UPDATE collections SET parent_id = ? WHERE parent_id = ?`, parentID, id)
+	if err != nil {
+		//a child with the same name as a sibling of the deleted collection
+		var mySQLError *mysql.MySQLError
+		if errors.As(err, &mySQLError) && mySQLError.Number == 1062 {
+			return ErrDuplicateCollection
+		}
+		return err
+	}
+	_, err = tx.Exec(`DELETE FROM collections WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ListByCollection This will return a page of the snippets of a user in a collection, not counting the ones in the
+// collections nested in it.
+func (m *SnippetModel) ListByCollection(userID int, collectionID int, opts ListOptions) ([]*Snippet, bool, error) {
+	return m.listWhere("", "s.user_id = ? AND s.collection_id = ?", []any{userID, collectionID}, opts)
+}
+
+// Move This will put a snippet of a user into one of the collections of the same user, collectionID 0 takes it out of
+// its collection.
+func (m *SnippetModel) Move(id int, userID int, collectionID int) error {
+	stmt := `UPDATE snippets SET collection_id = NULLIF(?, 0)
+	WHERE deleted_at IS NULL AND user_snippet_id = ? AND user_id = ?
+	AND (? = 0 OR EXISTS (SELECT 1 FROM collections WHERE id = ? AND user_id = ?))`
+	result, err := m.DB.Exec(stmt, collectionID, id, userID, collectionID, collectionID, userID)
+	if err != nil {
+		return err
+	}
+	//moving a snippet into the collection it is already in doesn't change the row
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		var found bool
+		err = m.DB.QueryRow(`SELECT EXISTS (SELECT 1 FROM snippets s WHERE s.deleted_at IS NULL AND s.user_snippet_id = ? AND s.user_id = ?
+		AND COALESCE(s.collection_id, 0) = ?)`, id, userID, collectionID).Scan(&found)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return ErrNoRecord
+		}
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"snippetbox.xyh.net/internal/envelope"
+)
+
+// Encryption at rest seals the content column of snippets, their revisions and their files with the envelope package.
+// Sealed values are longer than the plain text, so the columns that were TEXT are widened:
+//
+//	ALTER TABLE snippets MODIFY content MEDIUMTEXT NOT NULL;
+//	ALTER TABLE snippet_revisions MODIFY content MEDIUMTEXT NOT NULL;
+//
+// Rows written before encryption was enabled stay readable as plain text until Reencrypt reaches them. Snippets
+// encrypted in the browser are left alone, the server can't read them anyway.
+
+var ErrNoMasterKey = errors.New("models: content is encrypted but no master key is configured")
+
+// sealContent encrypts content with the master key, or returns it unchanged when encryption is disabled
+func (m *SnippetModel) sealContent(content string) (string, error) {
+	if m.Keys == nil {
+		return content, nil
+	}
+	return m.Keys.Seal(content)
+}
+
+// openContent decrypts content read from the database, plain text is returned unchanged
+func (m *SnippetModel) openContent(content string) (string, error) {
+	if !envelope.IsSealed(content) {
+		return content, nil
+	}
+	if m.Keys == nil {
+		return "", ErrNoMasterKey
+	}
+	return m.Keys.Open(content)
+}
+
+// openRevision decrypts the content of a revision unless it was encrypted in the browser
+func (m *SnippetModel) openRevision(r *Revision) error {
+	if r.Encrypted {
+		return nil
+	}
+	var err error
+	r.Content, err = m.openContent(r.Content)
+	return err
+}
+
+// ReencryptSnippets This will make sure the content of up to batchSize snippets with a primary key above afterID is
+// sealed with the current master key. It returns the last primary key it looked at, which is passed back in as
+// afterID for the next batch, and the number of rows that were rewritten. A last id of 0 means there are no rows left.
+func (m *SnippetModel) ReencryptSnippets(afterID int, batchSize int) (int, int, error) {
+	return m.reencrypt("snippets", afterID, batchSize)
+}
+
+// ReencryptRevisions This will do the same as ReencryptSnippets for the snippet_revisions table.
+func (m *SnippetModel) ReencryptRevisions(afterID int, batchSize int) (int, int, error) {
+	return m.reencrypt("snippet_revisions", afterID, batchSize)
+}
+
+// ReencryptFiles This will do the same as ReencryptSnippets for the snippet_files table.
+func (m *SnippetModel) ReencryptFiles(afterID int, batchSize int) (int, int, error) {
+	return m.reencrypt("snippet_files", afterID, batchSize)
+}
+
+// ReencryptRevisionFiles This will do the same as ReencryptSnippets for the snippet_revision_files table.
+func (m *SnippetModel) ReencryptRevisionFiles(afterID int, batchSize int) (int, int, error) {
+	return m.reencrypt("snippet_revision_files", afterID, batchSize)
+}
+
+// reencrypt rewraps or seals the content of one batch of rows of a snippet table, table is never taken from user input
+func (m *SnippetModel) reencrypt(table string, afterID int, batchSize int) (int, int, error) {
+	return reencryptColumn(m.DB, m.Keys, table, "id", "content", "NOT encrypted", afterID, batchSize)
+}
+
+// reencryptColumn rewraps or seals a column of one batch of the rows matching filter, ordered by their key column. None
+// of the names are ever taken from user input
+func reencryptColumn(db *sql.DB, keys *envelope.Keyring, table, key, column, filter string, afterID int, batchSize int) (int, int, error) {
+	if keys == nil {
+		return 0, 0, ErrNoMasterKey
+	}
+
+	stmt := `SELECT ` + key + `, ` + column + ` FROM ` + table + ` WHERE ` + key + ` > ? AND ` + filter + ` ORDER BY ` + key + ` LIMIT ?`
+	rows, err := db.Query(stmt, afterID, batchSize)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer rows.Close()
+
+	type row struct {
+		id      int
+		content string
+	}
+	batch := []row{}
+	for rows.Next() {
+		var r row
+		err = rows.Scan(&r.id, &r.content)
+		if err != nil {
+			return 0, 0, err
+		}
+		batch = append(batch, r)
+	}
+	if err = rows.Err(); err != nil {
+		return 0, 0, err
+	}
+
+	lastID, changed := 0, 0
+	for _, r := range batch {
+		lastID = r.id
+		content, ok, err := keys.Rewrap(r.content)
+		if err != nil {
+			return 0, 0, err
+		}
+		if !ok {
+			continue
+		}
+		//the old content is part of the WHERE clause, so a snippet edited since it was read is not overwritten. The
+		//edit already sealed it with the current key
+		result, err := db.Exec(`UPDATE `+table+` SET `+column+` = ? WHERE `+key+` = ? AND `+column+` = ?`, content, r.id, r.content)
+		if err != nil {
+			return 0, 0, err
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			return 0, 0, err
+		}
+		changed += int(n)
+	}
+	return lastID, changed, nil
+}
@@ -0,0 +1,143 @@
+package models
+
+import (
+	"database/sql"
+)
+
+// A snippet holds an ordered list of files. The first one is kept in the snippets row, so the lists and the snippets
+// written before there were several files keep working, the others go into snippet_files, which has a FULLTEXT index
+// of its own for Search. Updating a snippet
+// copies them to snippet_revision_files next to the revision:
+//
+//	ALTER TABLE snippets ADD filename VARCHAR(100) NOT NULL DEFAULT '';
+//	ALTER TABLE snippet_revisions ADD filename VARCHAR(100) NOT NULL DEFAULT '';
+//
+//	CREATE TABLE snippet_files (
+//		id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+//		snippet_id INTEGER NOT NULL,
+//		position INTEGER NOT NULL,
+//		name VARCHAR(100) NOT NULL,
+//		content MEDIUMTEXT NOT NULL,
+//		encrypted BOOLEAN NOT NULL DEFAULT FALSE,
+//		language VARCHAR(50) NOT NULL DEFAULT '',
+//		CONSTRAINT snippet_files_uc_position UNIQUE (snippet_id, position),
+//		CONSTRAINT fk_snippet_files_snippet FOREIGN KEY (snippet_id) REFERENCES snippets(id) ON DELETE CASCADE
+//	);
+//
+//	CREATE TABLE snippet_revision_files (
+//		id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+//		revision_id INTEGER NOT NULL,
+//		position INTEGER NOT NULL,
+//		name VARCHAR(100) NOT NULL,
+//		content MEDIUMTEXT NOT NULL,
+//		encrypted BOOLEAN NOT NULL DEFAULT FALSE,
+//		language VARCHAR(50) NOT NULL DEFAULT '',
+//		CONSTRAINT snippet_revision_files_uc_position UNIQUE (revision_id, position),
+//		CONSTRAINT fk_snippet_revision_files_revision FOREIGN KEY (revision_id) REFERENCES snippet_revisions(id) ON DELETE CASCADE
+//	);
+//
+// The encrypted flag is a copy of the one of the snippet, so the files can be re-encrypted on their own. Like the first
+// file, the files encrypted in the browser keep their ciphertext out of the content column and its FULLTEXT index:
+//
+//	ALTER TABLE snippet_files ADD ciphertext MEDIUMTEXT NULL;
+//	ALTER TABLE snippet_revision_files ADD ciphertext MEDIUMTEXT NULL;
+//	UPDATE snippet_files SET ciphertext = content, content = '' WHERE encrypted;
+//	UPDATE snippet_revision_files SET ciphertext = content, content = '' WHERE encrypted;
+
+// maximum number of files in a snippet
+const MaxFiles = 10
+
+// File is one of the files of a snippet or a revision
+type File struct {
+	Position int    //1 for the first file
+	Name     string //may be empty for the first file of the snippets written before there were several files
+	Content  string //the ciphertext if the snippet is encrypted
+	Language string //name of the syntax highlighting lexer, empty to detect it
+}
+
+// firstFile returns the file kept in the snippets row
+func (s *Snippet) firstFile() *File {
+	return &File{Position: 1, Name: s.Filename, Content: s.Content, Language: s.Language}
+}
+
+// setFiles replaces the files after the first one, it is called inside the transaction that writes the snippet
+func (m *SnippetModel) setFiles(tx *sql.Tx, snippetID int, encrypted bool, files []File) error {
+	_, err := tx.Exec("DELETE FROM snippet_files WHERE snippet_id = ?", snippetID)
+	if err != nil {
+		return err
+	}
+
+	for i, f := range files {
+		content, ciphertext, err := m.splitContent(f.Content, encrypted)
+		if err != nil {
+			return err
+		}
+		//the first file is the snippets row, so these start at position 2
+		stmt := `INSERT INTO snippet_files (snippet_id, position, name, content, ciphertext, encrypted, language) VALUES(?, ?, ?, ?, ?, ?, ?)`
+		_, err = tx.Exec(stmt, snippetID, i+2, f.Name, content, ciphertext, encrypted, f.Language)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// snapshotFiles copies the current files after the first one to a new revision, the content is copied as it is stored
+func snapshotFiles(tx *sql.Tx, snippetID int, revisionID int64) error {
+	stmt := `INSERT INTO snippet_revision_files (revision_id, position, name, content, ciphertext, encrypted, language)
+	SELECT ?, position, name, content, ciphertext, encrypted, language FROM snippet_files WHERE snippet_id = ?`
+	_, err := tx.Exec(stmt, revisionID, snippetID)
+	return err
+}
+
+// loadFiles fills in the Files field of a snippet, starting with the file kept in the snippets row
+func (m *SnippetModel) loadFiles(s *Snippet) error {
+	files, err := m.queryFiles(`SELECT position, name, IF(encrypted, ciphertext, content), encrypted, language FROM snippet_files WHERE snippet_id = ? ORDER BY position`, s.rowID)
+	if err != nil {
+		return err
+	}
+	s.Files = append([]*File{s.firstFile()}, files...)
+	return nil
+}
+
+// loadRevisionFiles fills in the Files field of a revision the same way
+func (m *SnippetModel) loadRevisionFiles(r *Revision) error {
+	files, err := m.queryFiles(`SELECT position, name, IF(encrypted, ciphertext, content), encrypted, language FROM snippet_revision_files WHERE revision_id = ? ORDER BY position`, r.rowID)
+	if err != nil {
+		return err
+	}
+	r.Files = append([]*File{{Position: 1, Name: r.Filename, Content: r.Content, Language: r.Language}}, files...)
+	return nil
+}
+
+// queryFiles reads and decrypts the files selected by a query on snippet_files or snippet_revision_files
+func (m *SnippetModel) queryFiles(stmt string, id int) ([]*File, error) {
+	rows, err := m.DB.Query(stmt, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	files := []*File{}
+
+	for rows.Next() {
+		f := &File{}
+		var encrypted bool
+		err = rows.Scan(&f.Position, &f.Name, &f.Content, &encrypted, &f.Language)
+		if err != nil {
+			return nil, err
+		}
+		if !encrypted {
+			f.Content, err = m.openContent(f.Content)
+			if err != nil {
+				return nil, err
+			}
+		}
+		files = append(files, f)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return files, nil
+}
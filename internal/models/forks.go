@@ -0,0 +1,61 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+)
+
+// A fork is a copy of a snippet owned by another user, or by the same one. It remembers the snippet it was copied from
+// for as long as that one exists:
+//
+//	ALTER TABLE snippets ADD forked_from INTEGER NULL;
+//	ALTER TABLE snippets ADD CONSTRAINT fk_snippets_forked_from FOREIGN KEY (forked_from) REFERENCES snippets(id) ON DELETE SET NULL;
+
+// loadOrigin fills in the Origin field of a fork. The origin is left out once it is in the trash or has expired, the
+// same as everywhere else
+func (m *SnippetModel) loadOrigin(s *Snippet) error {
+	if s.forkedFrom == 0 {
+		return nil
+	}
+
+	stmt := `SELECT ` + snippetColumns + ` FROM snippets s JOIN users u ON u.id = s.user_id
+	WHERE s.expires > UTC_TIMESTAMP() AND s.deleted_at IS NULL AND s.id = ?`
+	origin, err := m.scanSnippet(m.DB.QueryRow(stmt, s.forkedFrom))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		return err
+	}
+	s.Origin = origin
+	return nil
+}
+
+// Forks This will return the snippets forked from a snippet of a user, newest first. The private forks other users
+// made are left out, nothing about them is shown to the owner of the origin.
+func (m *SnippetModel) Forks(id int, userID int) ([]*Snippet, error) {
+	stmt := `SELECT ` + snippetColumns + ` FROM snippets s JOIN users u ON u.id = s.user_id
+	JOIN snippets o ON o.id = s.forked_from
+	WHERE s.expires > UTC_TIMESTAMP() AND s.deleted_at IS NULL AND o.user_snippet_id = ? AND o.user_id = ?
+	AND (s.user_id = o.user_id OR s.visibility <> 'private') ORDER BY s.created DESC, s.id DESC`
+	rows, err := m.DB.Query(stmt, id, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	forks := []*Snippet{}
+
+	for rows.Next() {
+		s, err := m.scanSnippet(rows)
+		if err != nil {
+			return nil, err
+		}
+		forks = append(forks, s)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return forks, nil
+}
@@ -0,0 +1,47 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Snippets shared through their link can be protected with a password, it is hashed with bcrypt in the same way as
+// the passwords of the users:
+//
+//	ALTER TABLE snippets ADD hashed_password CHAR(60) NULL;
+
+// hashSnippetPassword returns the bcrypt hash of a snippet password, or nil (NULL in the table) if it is empty
+func hashSnippetPassword(password string) (any, error) {
+	if password == "" {
+		return nil, nil
+	}
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+	return string(hashedPassword), nil
+}
+
+// CheckPassword This will return ErrInvalidCredentials if the password doesn't match the one of the snippet with the
+// given share link.
+func (m *SnippetModel) CheckPassword(slug string, password string) error {
+	var hashedPassword []byte
+	stmt := `SELECT hashed_password FROM snippets WHERE hashed_password IS NOT NULL AND deleted_at IS NULL AND slug = ?`
+	err := m.DB.QueryRow(stmt, slug).Scan(&hashedPassword)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNoRecord
+		}
+		return err
+	}
+
+	err = bcrypt.CompareHashAndPassword(hashedPassword, []byte(password))
+	if err != nil {
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return ErrInvalidCredentials
+		}
+		return err
+	}
+	return nil
+}
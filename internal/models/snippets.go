@@ -1,37 +1,219 @@
 package models
 
 import (
+	"crypto/rand"
 	"database/sql"
+	"encoding/base64"
 	"errors"
+	"fmt"
+	"github.com/go-sql-driver/mysql"
+	"math"
+	"snippetbox.xyh.net/internal/envelope"
+	"strings"
 	"time"
 )
 
-// Snippet value for an individual snippet
+// Snippet value for an individual snippet. The syntax highlighting language is kept with every version:
+//
+//	ALTER TABLE snippets ADD language VARCHAR(50) NOT NULL DEFAULT '';
+//	ALTER TABLE snippet_revisions ADD language VARCHAR(50) NOT NULL DEFAULT '';
+//
+// and so is the time of the last edit:
+//
+//	ALTER TABLE snippets ADD updated DATETIME NULL;
 type Snippet struct {
-	ID      int
-	Title   string
-	Content string
-	Created time.Time
-	Expires time.Time
+	ID            int
+	Title         string
+	Filename      string  //the name, content and language of the first file
+	Content       string  //the ciphertext if the snippet is encrypted
+	Encrypted     bool    //the content was encrypted in the browser, the server never sees the key
+	Language      string  //name of the syntax highlighting lexer, empty to detect it
+	Files         []*File //every file, starting with the first one. Only filled in for a single snippet
+	Tags          []string
+	Visibility    string
+	Slug          string //random identifier used in the share link of unlisted and public snippets
+	BurnAfterRead bool   //the snippet is deleted the first time someone other than its owner reads it
+	HasPassword   bool   //other users have to enter a password before the content is shown
+	UserID        int
+	Author        string //name of the owner
+	Created       time.Time
+	Updated       time.Time //the last time the snippet was edited, the same as Created if it never was
+	Expires       time.Time
+	Deleted       time.Time //zero unless the snippet is in the trash
+	Origin        *Snippet  //the snippet this one was forked from, only filled in for a single snippet
+	CollectionID  int       //0 if the snippet isn't in a collection
+	Stars         int       //number of users who starred the snippet, only filled in by LoadStars
+	Starred       bool      //the user passed to LoadStars starred the snippet
+	forkedFrom    int       //the primary key of the origin, 0 if the snippet is not a fork
+	rowID         int       //the primary key of the row, the ID above is only unique per user
+}
+
+// the visibility of a snippet, private snippets can only be viewed by their owner, unlisted ones by anyone who has the
+// share link and public ones are also listed on the explore page:
+//
+//	ALTER TABLE snippets ADD visibility ENUM('private', 'unlisted', 'public') NOT NULL DEFAULT 'private';
+//	ALTER TABLE snippets ADD slug CHAR(22) NULL;
+//	ALTER TABLE snippets ADD CONSTRAINT snippets_uc_slug UNIQUE (slug);
+//	CREATE INDEX idx_snippets_visibility ON snippets(visibility);
+const (
+	VisibilityPrivate  = "private"
+	VisibilityUnlisted = "unlisted"
+	VisibilityPublic   = "public"
+)
+
+// SnippetInput holds the values written by Insert and Update
+type SnippetInput struct {
+	Title         string
+	Files         []File //at least one
+	Encrypted     bool
+	Tags          []string
+	Visibility    string
+	BurnAfterRead bool
+	Password      string   //plain text, an empty password keeps the current one on Update
+	ClearPassword bool     //remove the password on Update
+	Expires       int      //number of days from now, 0 keeps the current expiry date on Update
+	ForkedFrom    *Snippet //the snippet copied by Insert, nil for a new snippet
+}
+
+// Revision holds a previous version of a snippet, it is written to the snippet_revisions table every time the
+// snippet is updated:
+//
+//	CREATE TABLE snippet_revisions (
+//		id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+//		snippet_id INTEGER NOT NULL,
+//		revision INTEGER NOT NULL,
+//		title VARCHAR(100) NOT NULL,
+//		content TEXT NOT NULL,
+//		expires DATETIME NOT NULL,
+//		created DATETIME NOT NULL,
+//		CONSTRAINT snippet_revisions_uc_revision UNIQUE (snippet_id, revision),
+//		CONSTRAINT fk_snippet_revisions_snippet FOREIGN KEY (snippet_id) REFERENCES snippets(id) ON DELETE CASCADE
+//	);
+type Revision struct {
+	Revision  int
+	Title     string
+	Filename  string
+	Content   string
+	Encrypted bool
+	Language  string
+	Files     []*File //only filled in by GetRevision
+	Expires   time.Time
+	Created   time.Time //the time this version was replaced
+	rowID     int
 }
 
 type SnippetModel struct {
-	DB *sql.DB
+	DB   *sql.DB
+	Keys *envelope.Keyring //encrypts the content at rest, nil stores it as plain text
+}
+
+// the columns read by scanSnippet, queries alias the snippets table as s and join the users table as u for the author
+const snippetColumns = `s.id, s.user_snippet_id, s.title, s.filename, IF(s.encrypted, s.ciphertext, s.content), s.encrypted, s.language, s.visibility, COALESCE(s.slug, ''), s.burn_after_read,
+	s.hashed_password IS NOT NULL, s.user_id, u.name, s.created, COALESCE(s.updated, s.created), s.expires, COALESCE(s.forked_from, 0), COALESCE(s.collection_id, 0)`
+
+// rowScanner is implemented by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// scanSnippet reads the snippetColumns of a row into a new snippet and decrypts its content, extra holds the
+// destinations of any columns selected after them
+func (m *SnippetModel) scanSnippet(row rowScanner, extra ...any) (*Snippet, error) {
+	s := &Snippet{}
+	dest := []any{&s.rowID, &s.ID, &s.Title, &s.Filename, &s.Content, &s.Encrypted, &s.Language, &s.Visibility, &s.Slug, &s.BurnAfterRead,
+		&s.HasPassword, &s.UserID, &s.Author, &s.Created, &s.Updated, &s.Expires, &s.forkedFrom, &s.CollectionID}
+	err := row.Scan(append(dest, extra...)...)
+	if err != nil {
+		return nil, err
+	}
+	if !s.Encrypted {
+		s.Content, err = m.openContent(s.Content)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
 }
 
 // Insert This will insert a new snippet into the database.
-func (m *SnippetModel) Insert(title string, content string, expires int, userID int) (int, error) {
-	//get the number of snippets created by the user
-	var maxID int
-	err := m.DB.QueryRow("SELECT COALESCE(MAX(user_snippet_id), 0) FROM snippets WHERE user_id = ?", userID).Scan(&maxID)
+// The per-user snippet id is taken from the user_snippet_counters table inside the same transaction as the insert, the
+// counter row is locked by the UPDATE so concurrent inserts from the same user are serialized:
+//
+//	CREATE TABLE user_snippet_counters (
+//		user_id INTEGER NOT NULL PRIMARY KEY,
+//		last_id INTEGER NOT NULL
+//	);
+//	ALTER TABLE snippets ADD CONSTRAINT snippets_uc_user_snippet UNIQUE (user_id, user_snippet_id);
+//
+// A deadlock or a duplicate id (only possible if snippets were written without going through the counter) rolls back
+// the transaction and the insert is retried. The per-user id of the new snippet is returned.
+func (m *SnippetModel) Insert(input SnippetInput, userID int) (int, error) {
+	var err error
+	for attempt := 0; attempt < maxInsertAttempts; attempt++ {
+		var id int
+		id, err = m.insert(input, userID)
+		if err == nil {
+			return id, nil
+		}
+		if !isRetryable(err) {
+			return 0, err
+		}
+	}
+	return 0, fmt.Errorf("models: allocating a snippet id failed after %d attempts: %w", maxInsertAttempts, err)
+}
+
+// maximum number of times Insert tries to allocate a snippet id
+const maxInsertAttempts = 5
+
+func (m *SnippetModel) insert(input SnippetInput, userID int) (int, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	//create the counter the first time a user creates a snippet
+	_, err = tx.Exec("INSERT IGNORE INTO user_snippet_counters (user_id, last_id) VALUES(?, 0)", userID)
+	if err != nil {
+		return 0, err
+	}
+	//increment the counter, this locks the row until the transaction ends.
+	//GREATEST() keeps the counter ahead of snippets that were numbered before the counter table existed
+	stmt := `UPDATE user_snippet_counters SET last_id = GREATEST(last_id, (SELECT COALESCE(MAX(user_snippet_id), 0) FROM snippets WHERE user_id = ?)) + 1 WHERE user_id = ?`
+	_, err = tx.Exec(stmt, userID, userID)
 	if err != nil {
 		return 0, err
 	}
 	//the new snippet id
-	userSnippetID := maxID + 1
+	var userSnippetID int
+	err = tx.QueryRow("SELECT last_id FROM user_snippet_counters WHERE user_id = ?", userID).Scan(&userSnippetID)
+	if err != nil {
+		return 0, err
+	}
+
+	//every snippet gets a slug, so the share link exists as soon as the visibility is changed
+	slug, err := newSlug()
+	if err != nil {
+		return 0, err
+	}
+
+	hashedPassword, err := hashSnippetPassword(input.Password)
+	if err != nil {
+		return 0, err
+	}
 
-	stmt := `INSERT INTO snippets (title, content, created, expires, user_id,  user_snippet_id) VALUES(?, ?, UTC_TIMESTAMP(), DATE_ADD(UTC_TIMESTAMP(), INTERVAL ? DAY), ?, ?)`
-	result, err := m.DB.Exec(stmt, title, content, expires, userID, userSnippetID)
+	first := input.Files[0]
+	content, ciphertext, err := m.splitContent(first.Content, input.Encrypted)
+	if err != nil {
+		return 0, err
+	}
+	var forkedFrom any
+	if input.ForkedFrom != nil {
+		forkedFrom = input.ForkedFrom.rowID
+	}
+	stmt = `INSERT INTO snippets (title, filename, content, ciphertext, encrypted, language, visibility, slug, burn_after_read, hashed_password, created, expires, user_id,  user_snippet_id, forked_from)
+	VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, UTC_TIMESTAMP(), DATE_ADD(UTC_TIMESTAMP(), INTERVAL ? DAY), ?, ?, ?)`
+	result, err := tx.Exec(stmt, input.Title, first.Name, content, ciphertext, input.Encrypted, first.Language, input.Visibility, slug, input.BurnAfterRead, hashedPassword, input.Expires, userID, userSnippetID, forkedFrom)
 	if err != nil {
 		return 0, err
 	}
@@ -40,19 +222,73 @@ func (m *SnippetModel) Insert(title string, content string, expires int, userID
 	if err != nil {
 		return 0, err
 	}
-	return int(id), err
+	err = m.setFiles(tx, int(id), input.Encrypted, input.Files[1:])
+	if err != nil {
+		return 0, err
+	}
+	err = setTags(tx, int(id), input.Tags)
+	if err != nil {
+		return 0, err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+	return userSnippetID, nil
+}
+
+// splitContent returns the values of the content and ciphertext columns. encrypted snippets keep their content in the
+// ciphertext column, which isn't part of the FULLTEXT index, and leave the content column empty:
+//
+//	ALTER TABLE snippets ADD encrypted BOOLEAN NOT NULL DEFAULT FALSE;
+//	ALTER TABLE snippets ADD ciphertext MEDIUMTEXT NULL;
+//	ALTER TABLE snippet_revisions ADD encrypted BOOLEAN NOT NULL DEFAULT FALSE;
+//
+// the content of other snippets is sealed with the master key when encryption at rest is enabled
+func (m *SnippetModel) splitContent(content string, encrypted bool) (string, any, error) {
+	if encrypted {
+		return "", content, nil
+	}
+	content, err := m.sealContent(content)
+	if err != nil {
+		return "", nil, err
+	}
+	return content, nil, nil
+}
+
+// newSlug returns 16 random bytes encoded as 22 url-safe characters, which can't be guessed
+func newSlug() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// isRetryable reports whether a failed snippet insert can safely be run again
+func isRetryable(err error) bool {
+	var mySQLError *mysql.MySQLError
+	if errors.As(err, &mySQLError) {
+		switch mySQLError.Number {
+		case 1213, 1205: //deadlock and lock wait timeout
+			return true
+		case 1062:
+			return strings.Contains(mySQLError.Message, "snippets_uc_user_snippet") || strings.Contains(mySQLError.Message, "snippets_uc_slug")
+		}
+	}
+	return false
 }
 
 // Get This will return a specific snippet based on its id.
 func (m *SnippetModel) Get(id int, userID int) (*Snippet, error) {
-	stmt := `SELECT user_snippet_id, title, content, created, expires FROM snippets WHERE expires > UTC_TIMESTAMP() AND user_snippet_id = ? AND user_id = ?`
+	stmt := `SELECT ` + snippetColumns + ` FROM snippets s JOIN users u ON u.id = s.user_id
+	WHERE s.expires > UTC_TIMESTAMP() AND s.deleted_at IS NULL AND s.user_snippet_id = ? AND s.user_id = ?`
 	//use the QueryRow method, this returns a pointer to the sql.Row object which hold the result from the database
 	row := m.DB.QueryRow(stmt, id, userID)
 
-	//initialize an pointer to an empty snippet, need pointer because the field of the struct will be passed in as parameter to row.Scan()
-	s := &Snippet{}
-
-	err := row.Scan(&s.ID, &s.Title, &s.Content, &s.Created, &s.Expires)
+	//scanSnippet initializes a pointer to an empty snippet and passes its fields to row.Scan()
+	s, err := m.scanSnippet(row)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, ErrNoRecord
@@ -60,16 +296,119 @@ func (m *SnippetModel) Get(id int, userID int) (*Snippet, error) {
 			return nil, err
 		}
 	}
+	err = m.loadTags([]*Snippet{s})
+	if err != nil {
+		return nil, err
+	}
+	err = m.loadFiles(s)
+	if err != nil {
+		return nil, err
+	}
+	err = m.loadOrigin(s)
+	if err != nil {
+		return nil, err
+	}
 	return s, nil
+}
+
+// GetBySlug This will return an unlisted or public snippet based on its share link, for any user.
+func (m *SnippetModel) GetBySlug(slug string) (*Snippet, error) {
+	stmt := `SELECT ` + snippetColumns + ` FROM snippets s JOIN users u ON u.id = s.user_id
+	WHERE s.expires > UTC_TIMESTAMP() AND s.deleted_at IS NULL AND s.visibility <> 'private' AND s.slug = ?`
 
+	s, err := m.scanSnippet(m.DB.QueryRow(stmt, slug))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		}
+		return nil, err
+	}
+	err = m.loadTags([]*Snippet{s})
+	if err != nil {
+		return nil, err
+	}
+	err = m.loadFiles(s)
+	if err != nil {
+		return nil, err
+	}
+	err = m.loadOrigin(s)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
 }
 
 // Latest This will return the 10 most recently created snippets.
 func (m *SnippetModel) Latest(userID int) ([]*Snippet, error) {
-	stmt := `SELECT  user_snippet_id, title, content, created, expires FROM snippets WHERE expires > UTC_TIMESTAMP() AND user_id = ? ORDER BY id DESC LIMIT 10`
-	rows, err := m.DB.Query(stmt, userID)
+	snippets, _, err := m.List(userID, ListOptions{Page: 1, PageSize: 10, Sort: "created"})
+	return snippets, err
+}
+
+// the sort orders accepted by List, the ORDER BY clause is only ever taken from this map so the user input never
+// reaches the SQL statement. id is used as a tie-breaker to keep the pages stable
+var snippetSorts = map[string]string{
+	"created": "s.created DESC, s.id DESC",
+	"expires": "s.expires ASC, s.id DESC",
+	"title":   "s.title ASC, s.id DESC",
+}
+
+const (
+	DefaultPageSize = 10
+	MaxPageSize     = 100
+	//the offset of the last page still fits in the signed 32 bit OFFSET of any platform, a bigger page number would
+	//overflow into a negative one
+	MaxPage = math.MaxInt32 / MaxPageSize
+)
+
+// ListOptions selects a page of snippets and the order they are listed in
+type ListOptions struct {
+	Page     int
+	PageSize int
+	Sort     string
+}
+
+// Normalize replaces out of range values with the defaults, it is called by every method that takes a ListOptions
+func (o *ListOptions) Normalize() {
+	if o.Page < 1 {
+		o.Page = 1
+	}
+	if o.Page > MaxPage {
+		o.Page = MaxPage
+	}
+	if o.PageSize < 1 || o.PageSize > MaxPageSize {
+		o.PageSize = DefaultPageSize
+	}
+	if _, ok := snippetSorts[o.Sort]; !ok {
+		o.Sort = "created"
+	}
+}
+
+// limit returns the LIMIT and OFFSET values for the page, one extra row is fetched to find out if there is a next page
+func (o ListOptions) limit() (int, int) {
+	return o.PageSize + 1, (o.Page - 1) * o.PageSize
+}
+
+// List This will return a page of the snippets of a user and whether there is a page after it.
+func (m *SnippetModel) List(userID int, opts ListOptions) ([]*Snippet, bool, error) {
+	return m.listWhere("", "s.user_id = ?", []any{userID}, opts)
+}
+
+// Explore This will return a page of the public snippets of every user.
+func (m *SnippetModel) Explore(opts ListOptions) ([]*Snippet, bool, error) {
+	return m.listWhere("", "s.visibility = 'public' AND NOT s.burn_after_read", nil, opts)
+}
+
+// listWhere returns a page of the snippets selected by the join and where clauses, expired snippets and the ones in the
+// trash are always left out. The snippets table is aliased as s and the users table as u
+func (m *SnippetModel) listWhere(join string, where string, args []any, opts ListOptions) ([]*Snippet, bool, error) {
+	opts.Normalize()
+	limit, offset := opts.limit()
+
+	stmt := `SELECT ` + snippetColumns + ` FROM snippets s JOIN users u ON u.id = s.user_id ` + join + `
+	WHERE s.expires > UTC_TIMESTAMP() AND s.deleted_at IS NULL AND ` + where + ` ORDER BY ` + snippetSorts[opts.Sort] + ` LIMIT ? OFFSET ?`
+	rows, err := m.DB.Query(stmt, append(args, limit, offset)...)
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
 	//this resource should be closed before this method returns
 	//this is very important , especially in the case of an error
@@ -78,19 +417,408 @@ func (m *SnippetModel) Latest(userID int) ([]*Snippet, error) {
 	snippets := []*Snippet{}
 
 	for rows.Next() {
-		s := &Snippet{}
-		err = rows.Scan(&s.ID, &s.Title, &s.Content, &s.Created, &s.Expires)
+		s, err := m.scanSnippet(rows)
 		if err != nil {
-			return nil, err
+			return nil, false, err
 		}
 		//append the struct to the slice
 		snippets = append(snippets, s)
 	}
 	//check for any errors encountered during the process
+	if err = rows.Err(); err != nil {
+		return nil, false, err
+	}
+
+	snippets, hasNext := trimPage(snippets, opts.PageSize)
+	err = m.loadTags(snippets)
+	if err != nil {
+		return nil, false, err
+	}
+	return snippets, hasNext, nil
+}
+
+// SearchResult is a snippet returned by Search together with its relevance score
+type SearchResult struct {
+	*Snippet
+	Score float64
+}
+
+// Search This will return a page of the snippets of a user matching the query, the most relevant first. It relies on
+// a FULLTEXT index over the title and content columns for the first file, and another one over the other files, a
+// snippet is ranked by its best file:
+//
+//	ALTER TABLE snippets ADD FULLTEXT INDEX snippets_ft_title_content (title, content);
+//	ALTER TABLE snippet_files ADD FULLTEXT INDEX snippet_files_ft_name_content (name, content);
+//
+// The sort option is ignored since the results are always ranked. When encryption at rest is enabled the content
+// column only holds sealed text, so the titles are searched on their own, see SearchesContent:
+//
+//	ALTER TABLE snippets ADD FULLTEXT INDEX snippets_ft_title (title);
+func (m *SnippetModel) Search(userID int, query string, opts ListOptions) ([]*SearchResult, bool, error) {
+	opts.Normalize()
+	limit, offset := opts.limit()
+
+	//MATCH has to name the exact columns of one of the indexes
+	stmt := `SELECT ` + snippetColumns + `, MATCH(s.title) AGAINST(? IN NATURAL LANGUAGE MODE) AS score
+	FROM snippets s JOIN users u ON u.id = s.user_id
+	WHERE MATCH(s.title) AGAINST(? IN NATURAL LANGUAGE MODE) AND s.expires > UTC_TIMESTAMP() AND s.deleted_at IS NULL AND s.user_id = ?
+	ORDER BY score DESC, s.id DESC LIMIT ? OFFSET ?`
+	args := []any{query, query, userID, limit, offset}
+
+	if m.SearchesContent() {
+		//the files after the first one that match are found by the derived table, the snippet matches if its first
+		//file or any of the others does. The content of the files encrypted in the browser is empty
+		stmt = `SELECT ` + snippetColumns + `, GREATEST(MATCH(s.title, s.content) AGAINST(? IN NATURAL LANGUAGE MODE), COALESCE(f.score, 0)) AS score
+		FROM snippets s JOIN users u ON u.id = s.user_id
+		LEFT JOIN (
+			SELECT snippet_id, MAX(score) AS score FROM (
+				SELECT sf.snippet_id, MATCH(sf.name, sf.content) AGAINST(? IN NATURAL LANGUAGE MODE) AS score
+				FROM snippet_files sf JOIN snippets fs ON fs.id = sf.snippet_id
+				WHERE MATCH(sf.name, sf.content) AGAINST(? IN NATURAL LANGUAGE MODE) AND fs.user_id = ?
+			) fm GROUP BY snippet_id
+		) f ON f.snippet_id = s.id
+		WHERE (MATCH(s.title, s.content) AGAINST(? IN NATURAL LANGUAGE MODE) OR f.snippet_id IS NOT NULL)
+		AND s.expires > UTC_TIMESTAMP() AND s.deleted_at IS NULL AND s.user_id = ?
+		ORDER BY score DESC, s.id DESC LIMIT ? OFFSET ?`
+		args = []any{query, query, query, userID, query, userID, limit, offset}
+	}
+
+	rows, err := m.DB.Query(stmt, args...)
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+
+	//the scores are kept in a slice of their own so the page can be trimmed like the other lists
+	snippets := []*Snippet{}
+	scores := []float64{}
+
+	for rows.Next() {
+		var score float64
+		s, err := m.scanSnippet(rows, &score)
+		if err != nil {
+			return nil, false, err
+		}
+		snippets = append(snippets, s)
+		scores = append(scores, score)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, false, err
+	}
+
+	snippets, hasNext := trimPage(snippets, opts.PageSize)
+	err = m.loadTags(snippets)
+	if err != nil {
+		return nil, false, err
+	}
+
+	results := make([]*SearchResult, len(snippets))
+	for i, s := range snippets {
+		results[i] = &SearchResult{Snippet: s, Score: scores[i]}
+	}
+	return results, hasNext, nil
+}
+
+// SearchesContent reports whether Search looks at the content of the snippets, it only looks at their titles when the
+// content is encrypted at rest. The sealed text can't be indexed and keeping a plain text index next to it would give
+// away what the encryption protects
+func (m *SnippetModel) SearchesContent() bool {
+	return m.Keys == nil
+}
+
+// trimPage drops the extra row fetched by a paginated query and reports whether it was there
+func trimPage(snippets []*Snippet, pageSize int) ([]*Snippet, bool) {
+	if len(snippets) > pageSize {
+		return snippets[:pageSize], true
+	}
+	return snippets, false
+}
+
+// Update This will save the current version of a snippet as a revision and then overwrite it with the new values.
+// An expires value of 0 keeps the current expiry date.
+func (m *SnippetModel) Update(id int, userID int, input SnippetInput) error {
+	//the revision and the update need to be written together, so both statements are run in a transaction
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	//rollback is a no-op once the transaction has been committed
+	defer tx.Rollback()
+
+	//lock the snippet row so concurrent edits can't allocate the same revision number
+	var snippetID int
+	var oldTitle, oldFilename, oldContent, oldLanguage string
+	var oldEncrypted bool
+	var oldExpires time.Time
+	stmt := `SELECT id, title, filename, IF(encrypted, ciphertext, content), encrypted, language, expires FROM snippets
+	WHERE expires > UTC_TIMESTAMP() AND deleted_at IS NULL AND user_snippet_id = ? AND user_id = ? FOR UPDATE`
+	err = tx.QueryRow(stmt, id, userID).Scan(&snippetID, &oldTitle, &oldFilename, &oldContent, &oldEncrypted, &oldLanguage, &oldExpires)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNoRecord
+		}
+		return err
+	}
+
+	var revision int
+	err = tx.QueryRow("SELECT COALESCE(MAX(revision), 0) FROM snippet_revisions WHERE snippet_id = ?", snippetID).Scan(&revision)
+	if err != nil {
+		return err
+	}
+
+	stmt = `INSERT INTO snippet_revisions (snippet_id, revision, title, filename, content, encrypted, language, expires, created) VALUES(?, ?, ?, ?, ?, ?, ?, ?, UTC_TIMESTAMP())`
+	result, err := tx.Exec(stmt, snippetID, revision+1, oldTitle, oldFilename, oldContent, oldEncrypted, oldLanguage, oldExpires)
+	if err != nil {
+		return err
+	}
+	revisionID, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	err = snapshotFiles(tx, snippetID, revisionID)
+	if err != nil {
+		return err
+	}
+
+	//snippets created before visibility existed have no slug yet, they get one the first time they are saved
+	slug, err := newSlug()
+	if err != nil {
+		return err
+	}
+	first := input.Files[0]
+	content, ciphertext, err := m.splitContent(first.Content, input.Encrypted)
+	if err != nil {
+		return err
+	}
+	if input.Expires == 0 {
+		stmt = `UPDATE snippets SET title = ?, filename = ?, content = ?, ciphertext = ?, encrypted = ?, language = ?, visibility = ?, slug = COALESCE(slug, ?), burn_after_read = ?,
+		updated = UTC_TIMESTAMP() WHERE id = ?`
+		_, err = tx.Exec(stmt, input.Title, first.Name, content, ciphertext, input.Encrypted, first.Language, input.Visibility, slug, input.BurnAfterRead, snippetID)
+	} else {
+		stmt = `UPDATE snippets SET title = ?, filename = ?, content = ?, ciphertext = ?, encrypted = ?, language = ?, visibility = ?, slug = COALESCE(slug, ?), burn_after_read = ?,
+		updated = UTC_TIMESTAMP(), expires = DATE_ADD(UTC_TIMESTAMP(), INTERVAL ? DAY) WHERE id = ?`
+		_, err = tx.Exec(stmt, input.Title, first.Name, content, ciphertext, input.Encrypted, first.Language, input.Visibility, slug, input.BurnAfterRead, input.Expires, snippetID)
+	}
+	if err != nil {
+		return err
+	}
+
+	//the password is only touched when a new one is given or it is removed
+	if input.Password != "" || input.ClearPassword {
+		hashedPassword, err := hashSnippetPassword(input.Password)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`UPDATE snippets SET hashed_password = ? WHERE id = ?`, hashedPassword, snippetID)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = m.setFiles(tx, snippetID, input.Encrypted, input.Files[1:])
+	if err != nil {
+		return err
+	}
+	err = setTags(tx, snippetID, input.Tags)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Revisions This will return all the previous versions of a snippet, newest first.
+func (m *SnippetModel) Revisions(id int, userID int) ([]*Revision, error) {
+	stmt := `SELECT r.id, r.revision, r.title, r.filename, r.content, r.encrypted, r.language, r.expires, r.created FROM snippet_revisions r
+	JOIN snippets s ON s.id = r.snippet_id
+	WHERE s.expires > UTC_TIMESTAMP() AND s.deleted_at IS NULL AND s.user_snippet_id = ? AND s.user_id = ? ORDER BY r.revision DESC`
+	rows, err := m.DB.Query(stmt, id, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revisions := []*Revision{}
+
+	for rows.Next() {
+		r := &Revision{}
+		err = rows.Scan(&r.rowID, &r.Revision, &r.Title, &r.Filename, &r.Content, &r.Encrypted, &r.Language, &r.Expires, &r.Created)
+		if err != nil {
+			return nil, err
+		}
+		err = m.openRevision(r)
+		if err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, r)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return revisions, nil
+}
+
+// GetRevision This will return a single previous version of a snippet.
+func (m *SnippetModel) GetRevision(id int, userID int, revision int) (*Revision, error) {
+	stmt := `SELECT r.id, r.revision, r.title, r.filename, r.content, r.encrypted, r.language, r.expires, r.created FROM snippet_revisions r
+	JOIN snippets s ON s.id = r.snippet_id
+	WHERE s.expires > UTC_TIMESTAMP() AND s.deleted_at IS NULL AND s.user_snippet_id = ? AND s.user_id = ? AND r.revision = ?`
+
+	r := &Revision{}
+	err := m.DB.QueryRow(stmt, id, userID, revision).Scan(&r.rowID, &r.Revision, &r.Title, &r.Filename, &r.Content, &r.Encrypted, &r.Language, &r.Expires, &r.Created)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		}
+		return nil, err
+	}
+	err = m.openRevision(r)
+	if err != nil {
+		return nil, err
+	}
+	err = m.loadRevisionFiles(r)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Delete This will move a snippet into the trash of its owner by setting deleted_at, the row itself is kept until it
+// is purged. The column is added with:
+//
+//	ALTER TABLE snippets ADD deleted_at DATETIME NULL;
+//	CREATE INDEX idx_snippets_deleted_at ON snippets(deleted_at);
+func (m *SnippetModel) Delete(id int, userID int) error {
+	stmt := `UPDATE snippets SET deleted_at = UTC_TIMESTAMP() WHERE deleted_at IS NULL AND user_snippet_id = ? AND user_id = ?`
+	result, err := m.DB.Exec(stmt, id, userID)
+	if err != nil {
+		return err
+	}
+	return expectRow(result)
+}
+
+// Trash This will return the snippets in the trash of a user, most recently deleted first.
+func (m *SnippetModel) Trash(userID int) ([]*Snippet, error) {
+	stmt := `SELECT user_snippet_id, title, content, created, expires, deleted_at FROM snippets WHERE deleted_at IS NOT NULL AND user_id = ? ORDER BY deleted_at DESC`
+	rows, err := m.DB.Query(stmt, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	snippets := []*Snippet{}
+
+	for rows.Next() {
+		s := &Snippet{}
+		err = rows.Scan(&s.ID, &s.Title, &s.Content, &s.Created, &s.Expires, &s.Deleted)
+		if err != nil {
+			return nil, err
+		}
+		s.Content, err = m.openContent(s.Content)
+		if err != nil {
+			return nil, err
+		}
+		snippets = append(snippets, s)
+	}
 	if err = rows.Err(); err != nil {
 		return nil, err
 	}
 
-	//return the slice(all the rows queried)
 	return snippets, nil
 }
+
+// Export This will return every snippet of a user with its files and tags, including the expired ones and the ones
+// in the trash. It is meant for the download of all the data of a user, not for listing.
+func (m *SnippetModel) Export(userID int) ([]*Snippet, error) {
+	stmt := `SELECT ` + snippetColumns + `, s.deleted_at FROM snippets s JOIN users u ON u.id = s.user_id WHERE s.user_id = ? ORDER BY s.user_snippet_id`
+	rows, err := m.DB.Query(stmt, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	snippets := []*Snippet{}
+
+	for rows.Next() {
+		var deleted sql.NullTime
+		s, err := m.scanSnippet(rows, &deleted)
+		if err != nil {
+			return nil, err
+		}
+		s.Deleted = deleted.Time
+		snippets = append(snippets, s)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	//the files are read once the rows are closed, one snippet at a time
+	rows.Close()
+	for _, s := range snippets {
+		err = m.loadFiles(s)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = m.loadTags(snippets)
+	if err != nil {
+		return nil, err
+	}
+	return snippets, nil
+}
+
+// Restore This will move a snippet out of the trash.
+func (m *SnippetModel) Restore(id int, userID int) error {
+	stmt := `UPDATE snippets SET deleted_at = NULL WHERE deleted_at IS NOT NULL AND user_snippet_id = ? AND user_id = ?`
+	result, err := m.DB.Exec(stmt, id, userID)
+	if err != nil {
+		return err
+	}
+	return expectRow(result)
+}
+
+// Purge This will permanently delete a snippet that is in the trash, its revisions are removed by the foreign key.
+func (m *SnippetModel) Purge(id int, userID int) error {
+	stmt := `DELETE FROM snippets WHERE deleted_at IS NOT NULL AND user_snippet_id = ? AND user_id = ?`
+	result, err := m.DB.Exec(stmt, id, userID)
+	if err != nil {
+		return err
+	}
+	return expectRow(result)
+}
+
+// PurgeTrash This will permanently delete every snippet that has been in the trash for longer than the retention
+// period and return how many were removed.
+func (m *SnippetModel) PurgeTrash(retention time.Duration) (int64, error) {
+	stmt := `DELETE FROM snippets WHERE deleted_at IS NOT NULL AND deleted_at < DATE_SUB(UTC_TIMESTAMP(), INTERVAL ? SECOND)`
+	result, err := m.DB.Exec(stmt, int64(retention.Seconds()))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// DeleteExpired This will permanently delete up to batchSize snippets whose expiry date has passed and return how
+// many were removed. Callers keep calling it until fewer than batchSize rows come back, so a large backlog never holds
+// locks on the table for long.
+func (m *SnippetModel) DeleteExpired(batchSize int) (int64, error) {
+	stmt := `DELETE FROM snippets WHERE expires <= UTC_TIMESTAMP() ORDER BY expires LIMIT ?`
+	result, err := m.DB.Exec(stmt, batchSize)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// expectRow returns ErrNoRecord when a statement did not change any row
+func expectRow(result sql.Result) error {
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNoRecord
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"github.com/go-sql-driver/mysql"
+	"sort"
+	"sync"
+	"testing"
+)
+
+// many goroutines creating the first snippets of a new user at once: the counter row is created and locked by all of
+// them, which is where InnoDB reports deadlocks, so Insert has to retry some of them
+func TestSnippetModelInsertConcurrent(t *testing.T) {
+	db := newTestDB(t)
+	userID := newTestUser(t, db)
+	m := &SnippetModel{DB: db}
+
+	const n = 20
+	ids := make([]int, n)
+	errs := make([]error, n)
+
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			ids[i], errs[i] = m.Insert(SnippetInput{
+				Title:      fmt.Sprintf("Snippet %d", i),
+				Files:      []File{{Content: "content"}},
+				Visibility: VisibilityPrivate,
+				Expires:    1,
+			}, userID)
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("insert %d: %v", i, err)
+		}
+	}
+
+	//the ids of a new user start at 1 and have no gaps or duplicates
+	sort.Ints(ids)
+	for i, id := range ids {
+		if id != i+1 {
+			t.Fatalf("got ids %v; want 1 to %d", ids, n)
+		}
+	}
+
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM snippets WHERE user_id = ?", userID).Scan(&count)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != n {
+		t.Errorf("got %d rows; want %d", count, n)
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{
+			name: "Deadlock",
+			err:  &mysql.MySQLError{Number: 1213, Message: "Deadlock found when trying to get lock"},
+			want: true,
+		},
+		{
+			name: "Lock wait timeout",
+			err:  &mysql.MySQLError{Number: 1205, Message: "Lock wait timeout exceeded"},
+			want: true,
+		},
+		{
+			name: "Duplicate snippet id",
+			err:  &mysql.MySQLError{Number: 1062, Message: "Duplicate entry '1-3' for key 'snippets.snippets_uc_user_snippet'"},
+			want: true,
+		},
+		{
+			name: "Duplicate slug",
+			err:  &mysql.MySQLError{Number: 1062, Message: "Duplicate entry 'abc' for key 'snippets.snippets_uc_slug'"},
+			want: true,
+		},
+		{
+			name: "Wrapped deadlock",
+			err:  fmt.Errorf("inserting: %w", &mysql.MySQLError{Number: 1213}),
+			want: true,
+		},
+		{
+			name: "Other duplicate",
+			err:  &mysql.MySQLError{Number: 1062, Message: "Duplicate entry 'go' for key 'tags.tags_uc_name'"},
+			want: false,
+		},
+		{
+			name: "Other MySQL error",
+			err:  &mysql.MySQLError{Number: 1146, Message: "Table 'snippetbox.snippets' doesn't exist"},
+			want: false,
+		},
+		{
+			name: "Not a MySQL error",
+			err:  errors.New("connection refused"),
+			want: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isRetryable(tt.err); got != tt.want {
+				t.Errorf("got %t; want %t", got, tt.want)
+			}
+		})
+	}
+}
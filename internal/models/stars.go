@@ -0,0 +1,38 @@
+package models
+
+// Users can star the snippets they can view, their own ones and the unlisted and public ones of other users:
+//
+//	CREATE TABLE stars (
+//		user_id INTEGER NOT NULL,
+//		snippet_id INTEGER NOT NULL,
+//		created DATETIME NOT NULL,
+//		PRIMARY KEY (user_id, snippet_id),
+//		CONSTRAINT fk_stars_user FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+//		CONSTRAINT fk_stars_snippet FOREIGN KEY (snippet_id) REFERENCES snippets(id) ON DELETE CASCADE
+//	);
+//	CREATE INDEX idx_stars_snippet ON stars(snippet_id);
+
+// Star This will add a snippet to the starred snippets of a user, starring it twice does nothing.
+func (m *SnippetModel) Star(s *Snippet, userID int) error {
+	_, err := m.DB.Exec(`INSERT IGNORE INTO stars (user_id, snippet_id, created) VALUES(?, ?, UTC_TIMESTAMP())`, userID, s.rowID)
+	return err
+}
+
+// Unstar This will remove a snippet from the starred snippets of a user.
+func (m *SnippetModel) Unstar(s *Snippet, userID int) error {
+	_, err := m.DB.Exec(`DELETE FROM stars WHERE user_id = ? AND snippet_id = ?`, userID, s.rowID)
+	return err
+}
+
+// LoadStars This will fill in the Stars and Starred fields of a snippet, Starred is about the given user.
+func (m *SnippetModel) LoadStars(s *Snippet, userID int) error {
+	stmt := `SELECT COUNT(*), COALESCE(SUM(user_id = ?), 0) > 0 FROM stars WHERE snippet_id = ?`
+	return m.DB.QueryRow(stmt, userID, s.rowID).Scan(&s.Stars, &s.Starred)
+}
+
+// Starred This will return a page of the snippets starred by a user. A snippet of another user is left out once it
+// is made private again, it comes back if it is shared again.
+func (m *SnippetModel) Starred(userID int, opts ListOptions) ([]*Snippet, bool, error) {
+	join := `JOIN stars st ON st.snippet_id = s.id`
+	return m.listWhere(join, "st.user_id = ? AND (s.user_id = st.user_id OR s.visibility <> 'private')", []any{userID}, opts)
+}
@@ -0,0 +1,89 @@
+package models
+
+import (
+	"database/sql"
+	"strings"
+)
+
+// Tags are stored once in the tags table and linked to the snippets through the snippet_tags join table:
+//
+//	CREATE TABLE tags (
+//		id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+//		name VARCHAR(30) NOT NULL,
+//		CONSTRAINT tags_uc_name UNIQUE (name)
+//	);
+//
+//	CREATE TABLE snippet_tags (
+//		snippet_id INTEGER NOT NULL,
+//		tag_id INTEGER NOT NULL,
+//		PRIMARY KEY (snippet_id, tag_id),
+//		CONSTRAINT fk_snippet_tags_snippet FOREIGN KEY (snippet_id) REFERENCES snippets(id) ON DELETE CASCADE,
+//		CONSTRAINT fk_snippet_tags_tag FOREIGN KEY (tag_id) REFERENCES tags(id)
+//	);
+
+// ListByTag This will return a page of the snippets of a user carrying the given tag.
+func (m *SnippetModel) ListByTag(userID int, tag string, opts ListOptions) ([]*Snippet, bool, error) {
+	join := `JOIN snippet_tags st ON st.snippet_id = s.id JOIN tags t ON t.id = st.tag_id`
+	return m.listWhere(join, "s.user_id = ? AND t.name = ?", []any{userID, tag}, opts)
+}
+
+// setTags replaces the tags of a snippet, it is called inside the transaction that writes the snippet
+func setTags(tx *sql.Tx, snippetID int, tags []string) error {
+	_, err := tx.Exec("DELETE FROM snippet_tags WHERE snippet_id = ?", snippetID)
+	if err != nil {
+		return err
+	}
+
+	for _, tag := range tags {
+		//LAST_INSERT_ID(id) makes LastInsertId() return the id of the existing row when the tag is already there
+		result, err := tx.Exec("INSERT INTO tags (name) VALUES(?) ON DUPLICATE KEY UPDATE id = LAST_INSERT_ID(id)", tag)
+		if err != nil {
+			return err
+		}
+		tagID, err := result.LastInsertId()
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec("INSERT IGNORE INTO snippet_tags (snippet_id, tag_id) VALUES(?, ?)", snippetID, tagID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadTags fills in the Tags field of the snippets with a single query
+func (m *SnippetModel) loadTags(snippets []*Snippet) error {
+	if len(snippets) == 0 {
+		return nil
+	}
+
+	byRowID := make(map[int]*Snippet, len(snippets))
+	args := make([]any, 0, len(snippets))
+	for _, s := range snippets {
+		s.Tags = []string{}
+		byRowID[s.rowID] = s
+		args = append(args, s.rowID)
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(args)), ", ")
+	stmt := `SELECT st.snippet_id, t.name FROM snippet_tags st JOIN tags t ON t.id = st.tag_id WHERE st.snippet_id IN (` + placeholders + `) ORDER BY t.name`
+	rows, err := m.DB.Query(stmt, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var snippetID int
+		var name string
+		err = rows.Scan(&snippetID, &name)
+		if err != nil {
+			return err
+		}
+		if s, ok := byRowID[snippetID]; ok {
+			s.Tags = append(s.Tags, name)
+		}
+	}
+	return rows.Err()
+}
@@ -0,0 +1,49 @@
+package models
+
+import (
+	"database/sql"
+	"os"
+	"testing"
+)
+
+// newTestDB opens the database named by SNIPPETBOX_TEST_DSN, which must already have the tables described in the doc
+// comments of this package, e.g. "test_web:pass@/test_snippetbox?parseTime=true". The tests that need it are skipped
+// when it isn't set, or with -short
+func newTestDB(t *testing.T) *sql.DB {
+	dsn := os.Getenv("SNIPPETBOX_TEST_DSN")
+	if dsn == "" || testing.Short() {
+		t.Skip("models: SNIPPETBOX_TEST_DSN not set, skipping the database tests")
+	}
+
+	db, err := sql.Open("mysql", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = db.Ping(); err != nil {
+		db.Close()
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// newTestUser adds a user with a unique email address and deletes it, with everything it owns, once the test is over
+func newTestUser(t *testing.T, db *sql.DB) int {
+	users := &UserModel{DB: db}
+
+	slug, err := newSlug()
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := users.Insert("Test User", "test-"+slug+"@example.com", "pa55word")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		err := users.Delete(id)
+		if err != nil {
+			t.Errorf("deleting the test user: %v", err)
+		}
+	})
+	return id
+}
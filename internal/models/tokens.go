@@ -0,0 +1,124 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"golang.org/x/crypto/bcrypt"
+	"time"
+)
+
+// Tokens are the single-use secrets sent to the users by email. Only their sha256 hash is stored, so the table can't
+// be used to take over an account, and the scope keeps a token from being used for anything but what it was sent for:
+//
+//	CREATE TABLE tokens (
+//		hash CHAR(64) NOT NULL PRIMARY KEY,
+//		user_id INTEGER NOT NULL,
+//		scope VARCHAR(30) NOT NULL,
+//		expires DATETIME NOT NULL,
+//		CONSTRAINT fk_tokens_user FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+//	);
+//	CREATE INDEX idx_tokens_expires ON tokens(expires);
+const (
+	ScopePasswordReset     = "password-reset"
+	ScopeEmailVerification = "email-verification"
+)
+
+var ErrInvalidToken = errors.New("models: invalid or expired token")
+
+// hashToken returns the hex encoded sha256 hash of a token, the way it is stored
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// NewToken This will create a token for a user that is valid for the given time, the plain text token is returned and
+// never stored.
+func (m *UserModel) NewToken(userID int, scope string, ttl time.Duration) (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+
+	stmt := `INSERT INTO tokens (hash, user_id, scope, expires) VALUES(?, ?, ?, DATE_ADD(UTC_TIMESTAMP(), INTERVAL ? SECOND))`
+	_, err = m.DB.Exec(stmt, hashToken(token), userID, scope, int(ttl.Seconds()))
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// useToken looks up the user of a token that hasn't expired and deletes every token of that user with the same scope,
+// so the token and any other one sent before it can't be used again. It runs inside the caller's transaction
+func useToken(tx *sql.Tx, token string, scope string) (int, error) {
+	var userID int
+	stmt := `SELECT user_id FROM tokens WHERE hash = ? AND scope = ? AND expires > UTC_TIMESTAMP() FOR UPDATE`
+	err := tx.QueryRow(stmt, hashToken(token), scope).Scan(&userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrInvalidToken
+		}
+		return 0, err
+	}
+
+	_, err = tx.Exec(`DELETE FROM tokens WHERE user_id = ? AND scope = ?`, userID, scope)
+	if err != nil {
+		return 0, err
+	}
+	return userID, nil
+}
+
+// CheckToken This will return ErrInvalidToken if the token doesn't exist, has expired or has another scope. The token
+// stays valid.
+func (m *UserModel) CheckToken(token string, scope string) error {
+	var exists bool
+	stmt := `SELECT EXISTS(SELECT true FROM tokens WHERE hash = ? AND scope = ? AND expires > UTC_TIMESTAMP())`
+	err := m.DB.QueryRow(stmt, hashToken(token), scope).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrInvalidToken
+	}
+	return nil
+}
+
+// ResetPassword This will replace the password of the user a password reset token was sent to and use up the token.
+// The id of the user is returned.
+func (m *UserModel) ResetPassword(token string, password string) (int, error) {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	userID, err := useToken(tx, token, ScopePasswordReset)
+	if err != nil {
+		return 0, err
+	}
+	//the link was sent to the address of the user, so following it verifies the address as well
+	_, err = tx.Exec(`UPDATE users SET hashed_password = ?, verified = TRUE WHERE id = ?`, string(hashedPassword), userID)
+	if err != nil {
+		return 0, err
+	}
+	return userID, tx.Commit()
+}
+
+// DeleteExpiredTokens This will remove the tokens that can't be used anymore and return how many there were.
+func (m *UserModel) DeleteExpiredTokens() (int64, error) {
+	result, err := m.DB.Exec(`DELETE FROM tokens WHERE expires <= UTC_TIMESTAMP()`)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
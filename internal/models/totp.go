@@ -0,0 +1,213 @@
+package models
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"snippetbox.xyh.net/internal/envelope"
+	"strings"
+)
+
+// Users can add a second step to their login with the time-based codes of an authenticator app. The secret is always
+// sealed with the master key, so it can only be set up when encryption at rest is enabled. It is written when
+// the user starts setting it up and only used at login once a code was entered to confirm it. last_step is the period
+// of the last code that was accepted, so a code can't be used twice:
+//
+//	CREATE TABLE totp (
+//		user_id INTEGER NOT NULL PRIMARY KEY,
+//		secret VARCHAR(255) NOT NULL,
+//		enabled BOOLEAN NOT NULL DEFAULT FALSE,
+//		last_step BIGINT NOT NULL DEFAULT 0,
+//		created DATETIME NOT NULL,
+//		CONSTRAINT fk_totp_user FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+//	);
+//
+// The recovery codes log in without the app, each one once. Only their sha256 hash is stored, like the tokens:
+//
+//	CREATE TABLE recovery_codes (
+//		hash CHAR(64) NOT NULL PRIMARY KEY,
+//		user_id INTEGER NOT NULL,
+//		CONSTRAINT fk_recovery_codes_user FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+//	);
+
+// RecoveryCodes is the number of recovery codes a user gets
+const RecoveryCodes = 10
+
+// TOTPAvailable reports whether two-factor authentication can be set up, which needs a master key to seal the secrets
+func (m *UserModel) TOTPAvailable() bool {
+	return m.Keys != nil
+}
+
+// sealSecret encrypts a secret with the master key, unlike the snippets it is never stored as plain text
+func (m *UserModel) sealSecret(secret string) (string, error) {
+	if m.Keys == nil {
+		return "", ErrNoMasterKey
+	}
+	return m.Keys.Seal(secret)
+}
+
+// TOTPSecret This will return the secret of a user and whether it is enabled, or ErrNoRecord if the user never started
+// setting it up.
+func (m *UserModel) TOTPSecret(userID int) (string, bool, error) {
+	var secret string
+	var enabled bool
+	err := m.DB.QueryRow(`SELECT secret, enabled FROM totp WHERE user_id = ?`, userID).Scan(&secret, &enabled)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", false, ErrNoRecord
+		}
+		return "", false, err
+	}
+	if envelope.IsSealed(secret) {
+		if m.Keys == nil {
+			return "", false, ErrNoMasterKey
+		}
+		secret, err = m.Keys.Open(secret)
+		if err != nil {
+			return "", false, err
+		}
+	}
+	return secret, enabled, nil
+}
+
+// SetTOTPSecret This will store a new secret for a user who is setting up two-factor authentication, it is not used
+// at login until EnableTOTP is called. The secret of a user who already enabled it is kept. ErrNoMasterKey is returned
+// when encryption at rest is disabled.
+func (m *UserModel) SetTOTPSecret(userID int, secret string) error {
+	sealed, err := m.sealSecret(secret)
+	if err != nil {
+		return err
+	}
+	stmt := `INSERT INTO totp (user_id, secret, created) VALUES(?, ?, UTC_TIMESTAMP())
+	ON DUPLICATE KEY UPDATE secret = IF(enabled, secret, VALUES(secret))`
+	_, err = m.DB.Exec(stmt, userID, sealed)
+	return err
+}
+
+// EnableTOTP This will turn on two-factor authentication once the user entered the code of the given period, and
+// return a new set of recovery codes.
+func (m *UserModel) EnableTOTP(userID int, step int64) ([]string, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`UPDATE totp SET enabled = TRUE, last_step = ? WHERE user_id = ? AND NOT enabled`, step, userID)
+	if err != nil {
+		return nil, err
+	}
+	err = expectRow(result)
+	if err != nil {
+		return nil, err
+	}
+	codes, err := setRecoveryCodes(tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return codes, tx.Commit()
+}
+
+// DisableTOTP This will turn off two-factor authentication and delete the secret and the recovery codes.
+func (m *UserModel) DisableTOTP(userID int) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`DELETE FROM totp WHERE user_id = ?`, userID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`DELETE FROM recovery_codes WHERE user_id = ?`, userID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UseTOTPStep This will record that the code of a period was used to log in, false means a code of that period or of
+// a later one was used already.
+func (m *UserModel) UseTOTPStep(userID int, step int64) (bool, error) {
+	result, err := m.DB.Exec(`UPDATE totp SET last_step = ? WHERE user_id = ? AND enabled AND last_step < ?`, step, userID, step)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n == 1, err
+}
+
+// NewRecoveryCodes This will replace the recovery codes of a user and return the new ones, this is the only time they
+// are available in plain text.
+func (m *UserModel) NewRecoveryCodes(userID int) ([]string, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	codes, err := setRecoveryCodes(tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return codes, tx.Commit()
+}
+
+// UseRecoveryCode This will delete a recovery code of a user and report whether it existed. Dashes, spaces and the
+// case of the code don't matter.
+func (m *UserModel) UseRecoveryCode(userID int, code string) (bool, error) {
+	result, err := m.DB.Exec(`DELETE FROM recovery_codes WHERE user_id = ? AND hash = ?`, userID, hashToken(normalizeRecoveryCode(code)))
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n == 1, err
+}
+
+// RecoveryCodesLeft This will return the number of recovery codes of a user that haven't been used.
+func (m *UserModel) RecoveryCodesLeft(userID int) (int, error) {
+	var n int
+	err := m.DB.QueryRow(`SELECT COUNT(*) FROM recovery_codes WHERE user_id = ?`, userID).Scan(&n)
+	return n, err
+}
+
+// ReencryptTOTPSecrets This will make sure the secrets of up to batchSize users with an id above afterID are sealed
+// with the current master key, the same as SnippetModel.ReencryptSnippets does for the snippets.
+func (m *UserModel) ReencryptTOTPSecrets(afterID int, batchSize int) (int, int, error) {
+	return reencryptColumn(m.DB, m.Keys, "totp", "user_id", "secret", "TRUE", afterID, batchSize)
+}
+
+// the recovery codes are 10 characters of base32 written as two groups of 5, 50 random bits each
+var recoveryEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// setRecoveryCodes replaces the recovery codes of a user inside the caller's transaction and returns the new ones
+func setRecoveryCodes(tx *sql.Tx, userID int) ([]string, error) {
+	_, err := tx.Exec(`DELETE FROM recovery_codes WHERE user_id = ?`, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, RecoveryCodes)
+	for i := range codes {
+		b := make([]byte, 7)
+		_, err = rand.Read(b)
+		if err != nil {
+			return nil, err
+		}
+		code := strings.ToLower(recoveryEncoding.EncodeToString(b)[:10])
+		codes[i] = code[:5] + "-" + code[5:]
+
+		_, err = tx.Exec(`INSERT INTO recovery_codes (hash, user_id) VALUES(?, ?)`, hashToken(code), userID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return codes, nil
+}
+
+// normalizeRecoveryCode removes the dash and spaces a recovery code may be typed with and lowercases it
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
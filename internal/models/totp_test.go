@@ -0,0 +1,67 @@
+package models
+
+import (
+	"crypto/rand"
+	"snippetbox.xyh.net/internal/envelope"
+	"testing"
+)
+
+// a code is only accepted once: the period it matched is recorded, and that period or an earlier one is refused
+// afterwards, even though totp.Validate still accepts its code
+func TestUserModelUseTOTPStep(t *testing.T) {
+	db := newTestDB(t)
+	userID := newTestUser(t, db)
+
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := envelope.NewKeyring(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &UserModel{DB: db, Keys: keys}
+
+	//a step can't be used before two-factor authentication is enabled
+	err = m.SetTOTPSecret(userID, "JBSWY3DPEHPK3PXP")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ok, err := m.UseTOTPStep(userID, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok {
+		t.Fatal("got a step used before enabling")
+	}
+
+	//enabling records the step of the confirmation code
+	_, err = m.EnableTOTP(userID, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		step int64
+		want bool
+	}{
+		{name: "Confirmation step", step: 100, want: false},
+		{name: "Next step", step: 101, want: true},
+		{name: "Reused step", step: 101, want: false},
+		{name: "Earlier step", step: 100, want: false},
+		{name: "Skipped steps", step: 105, want: true},
+		{name: "Step within the skew of the last one", step: 104, want: false},
+	}
+
+	for _, tt := range tests {
+		ok, err := m.UseTOTPStep(userID, tt.step)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if ok != tt.want {
+			t.Errorf("%s: got %t; want %t", tt.name, ok, tt.want)
+		}
+	}
+}
@@ -5,47 +5,61 @@ import (
 	"errors"
 	"github.com/go-sql-driver/mysql"
 	"golang.org/x/crypto/bcrypt"
+	"snippetbox.xyh.net/internal/envelope"
 	"strings"
 	"time"
 )
 
-// model of the user table
+// model of the user table. New users have to verify their email address by following a link sent to it, the users
+// that signed up before that are treated as verified:
+//
+//	ALTER TABLE users ADD verified BOOLEAN NOT NULL DEFAULT FALSE;
+//	UPDATE users SET verified = TRUE;
 type User struct {
 	ID             int
 	Name           string
 	Email          string
 	HashedPassword []byte
+	Verified       bool
+	TOTPEnabled    bool //the login asks for a code of an authenticator app after the password
 	Created        time.Time
 }
 
 // new model that wraps around a db connection pool
 type UserModel struct {
-	DB *sql.DB
+	DB   *sql.DB
+	Keys *envelope.Keyring //encrypts the TOTP secrets at rest, nil stores them as plain text
 }
 
-func (m *UserModel) Insert(name, email, password string) error {
+// Insert adds an unverified user and returns its id
+func (m *UserModel) Insert(name, email, password string) (int, error) {
 	//create a bcrypt hash encryption for the password
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
 	stmt := `INSERT INTO users (name, email, hashed_password, created) VALUES(?, ?, ?, UTC_TIMESTAMP())`
 
 	//insert into the db
-	_, err = m.DB.Exec(stmt, name, email, string(hashedPassword))
+	result, err := m.DB.Exec(stmt, name, email, string(hashedPassword))
 	if err != nil {
 		//the errors.As() function check if the err is of type *mysql.MySQLError, if it does, the error is assigned to our variable,
 		//this function also returns a bool
 		var mySQLError *mysql.MySQLError
 		if errors.As(err, &mySQLError) {
 			if mySQLError.Number == 1062 && strings.Contains(mySQLError.Message, "users_uc_email") {
-				return ErrDuplicateEmail
+				return 0, ErrDuplicateEmail
 			}
 		}
-		return err
+		return 0, err
 	}
-	return nil
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	return int(id), nil
 }
 
 // if exist, return user id
@@ -81,3 +95,215 @@ func (m *UserModel) Exists(id int) (bool, error) {
 	err := m.DB.QueryRow(stmt, id).Scan(&exists)
 	return exists, err
 }
+
+// Get This will return the user with the given id, or ErrNoRecord.
+func (m *UserModel) Get(id int) (*User, error) {
+	return m.getWhere("id = ?", id)
+}
+
+// GetByEmail This will return the user with the given email address, or ErrNoRecord.
+func (m *UserModel) GetByEmail(email string) (*User, error) {
+	return m.getWhere("email = ?", email)
+}
+
+// getWhere returns the user selected by the where clause, without the password hash
+func (m *UserModel) getWhere(where string, arg any) (*User, error) {
+	u := &User{}
+	stmt := `SELECT id, name, email, verified, EXISTS(SELECT true FROM totp t WHERE t.user_id = users.id AND t.enabled), created
+	FROM users WHERE ` + where
+	err := m.DB.QueryRow(stmt, arg).Scan(&u.ID, &u.Name, &u.Email, &u.Verified, &u.TOTPEnabled, &u.Created)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		}
+		return nil, err
+	}
+	return u, nil
+}
+
+// Verify This will mark the user an email verification token was sent to as verified and use up the token. The id of
+// the user is returned.
+func (m *UserModel) Verify(token string) (int, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	userID, err := useToken(tx, token, ScopeEmailVerification)
+	if err != nil {
+		return 0, err
+	}
+	_, err = tx.Exec(`UPDATE users SET verified = TRUE WHERE id = ?`, userID)
+	if err != nil {
+		return 0, err
+	}
+	return userID, tx.Commit()
+}
+
+// UpdateName This will change the display name of a user.
+func (m *UserModel) UpdateName(id int, name string) error {
+	_, err := m.DB.Exec(`UPDATE users SET name = ? WHERE id = ?`, name, id)
+	return err
+}
+
+// UpdateEmail This will change the email address of a user, who has to verify the new one. The tokens sent to the old
+// address can't be used anymore.
+func (m *UserModel) UpdateEmail(id int, email string) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`UPDATE users SET email = ?, verified = FALSE WHERE id = ?`, email, id)
+	if err != nil {
+		var mySQLError *mysql.MySQLError
+		if errors.As(err, &mySQLError) && mySQLError.Number == 1062 && strings.Contains(mySQLError.Message, "users_uc_email") {
+			return ErrDuplicateEmail
+		}
+		return err
+	}
+	_, err = tx.Exec(`DELETE FROM tokens WHERE user_id = ?`, id)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// CheckPassword This will return ErrInvalidCredentials if the password isn't the one of the user.
+func (m *UserModel) CheckPassword(id int, password string) error {
+	var hashedPassword []byte
+	err := m.DB.QueryRow(`SELECT hashed_password FROM users WHERE id = ?`, id).Scan(&hashedPassword)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNoRecord
+		}
+		return err
+	}
+	err = bcrypt.CompareHashAndPassword(hashedPassword, []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return ErrInvalidCredentials
+	}
+	return err
+}
+
+// UpdatePassword This will replace the password of a user if the current one matches, otherwise
+// ErrInvalidCredentials is returned.
+func (m *UserModel) UpdatePassword(id int, currentPassword, newPassword string) error {
+	err := m.CheckPassword(id, currentPassword)
+	if err != nil {
+		return err
+	}
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	_, err = m.DB.Exec(`UPDATE users SET hashed_password = ? WHERE id = ?`, string(hashedPassword), id)
+	if err != nil {
+		return err
+	}
+	//a reset link that was sent before is of no use anymore
+	_, err = m.DB.Exec(`DELETE FROM tokens WHERE user_id = ? AND scope = ?`, id, ScopePasswordReset)
+	return err
+}
+
+// The sessions of a user are recorded when they log in, so they can all be ended when the account is deleted. scs
+// keeps the sessions themselves in its own table and only knows them by their token:
+//
+//	CREATE TABLE sessions (
+//		token CHAR(43) PRIMARY KEY,
+//		data BLOB NOT NULL,
+//		expiry TIMESTAMP(6) NOT NULL
+//	);
+//
+//	CREATE TABLE user_sessions (
+//		token CHAR(43) NOT NULL PRIMARY KEY,
+//		user_id INTEGER NOT NULL,
+//		created DATETIME NOT NULL,
+//		CONSTRAINT fk_user_sessions_user FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+//	);
+
+// AddSession This will record that a session token belongs to a user.
+func (m *UserModel) AddSession(id int, token string) error {
+	_, err := m.DB.Exec(`INSERT IGNORE INTO user_sessions (token, user_id, created) VALUES(?, ?, UTC_TIMESTAMP())`, token, id)
+	return err
+}
+
+// DeleteExpiredSessions This will remove the sessions that are over from the table of scs and return how many there
+// were. The store doesn't clean up after itself, the reaper calls this so the number can be logged.
+func (m *UserModel) DeleteExpiredSessions() (int64, error) {
+	result, err := m.DB.Exec(`DELETE FROM sessions WHERE expiry < UTC_TIMESTAMP(6)`)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// DeleteStaleSessions This will forget the recorded sessions that scs has ended or that have expired. A session is
+// only written at the end of the request that started it, so the recent ones are kept until it is there.
+func (m *UserModel) DeleteStaleSessions() (int64, error) {
+	stmt := `DELETE us FROM user_sessions us LEFT JOIN sessions s ON s.token = us.token
+	WHERE (s.token IS NULL OR s.expiry < UTC_TIMESTAMP(6)) AND us.created < DATE_SUB(UTC_TIMESTAMP(), INTERVAL 1 HOUR)`
+	result, err := m.DB.Exec(stmt)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// DeleteSessions This will end every recorded session of a user except the one with the token keep, which can be
+// empty to end them all. Sessions the user started before they were recorded can't be found.
+func (m *UserModel) DeleteSessions(id int, keep string) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`DELETE FROM sessions WHERE token IN (SELECT token FROM user_sessions WHERE user_id = ? AND token <> ?)`, id, keep)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`DELETE FROM user_sessions WHERE user_id = ? AND token <> ?`, id, keep)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Delete This will remove a user together with everything they own: their snippets with their files, revisions and
+// tags, their collections, stars and tokens, and every session they are logged in with. Forks other users made of
+// their snippets are kept. It all happens in one transaction.
+func (m *UserModel) Delete(id int) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	//the statements run in this order because of the foreign keys, the rest is removed by ON DELETE CASCADE
+	stmts := []string{
+		`DELETE FROM sessions WHERE token IN (SELECT token FROM user_sessions WHERE user_id = ?)`,
+		`DELETE FROM snippets WHERE user_id = ?`,
+		`UPDATE collections SET parent_id = NULL WHERE user_id = ?`,
+		`DELETE FROM collections WHERE user_id = ?`,
+		`DELETE FROM user_snippet_counters WHERE user_id = ?`,
+	}
+	for _, stmt := range stmts {
+		_, err = tx.Exec(stmt, id)
+		if err != nil {
+			return err
+		}
+	}
+
+	result, err := tx.Exec(`DELETE FROM users WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	err = expectRow(result)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,114 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Limiter counts attempts per key (e.g. a snippet or an email address) in a fixed window of time, once max attempts
+// have been recorded for a key, Allow returns false until the window that started with the first attempt is over.
+// the counts are kept in memory, so they are per process and reset on restart
+type Limiter struct {
+	mu        sync.Mutex
+	max       int
+	window    time.Duration
+	attempts  map[string]*window
+	lastPrune time.Time
+}
+
+type window struct {
+	start time.Time
+	count int
+}
+
+func New(max int, period time.Duration) *Limiter {
+	return &Limiter{
+		max:       max,
+		window:    period,
+		attempts:  map[string]*window{},
+		lastPrune: time.Now(),
+	}
+}
+
+// Allow reports whether another attempt can be made for the key, it doesn't record one
+func (l *Limiter) Allow(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	w, ok := l.attempts[key]
+	if !ok || time.Since(w.start) >= l.window {
+		return true
+	}
+	return w.count < l.max
+}
+
+// Add records an attempt for the key
+func (l *Limiter) Add(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.prune(now)
+
+	w, ok := l.attempts[key]
+	if !ok || now.Sub(w.start) >= l.window {
+		l.attempts[key] = &window{start: now, count: 1}
+		return
+	}
+	w.count++
+}
+
+// Hit records an attempt for the key and reports whether it was allowed, under the same lock. Checking with Allow and
+// recording with Add after a slow check lets concurrent attempts all pass Allow before any of them is recorded, so
+// guesses should reserve their attempt with Hit before they are checked. An attempt over the limit isn't recorded
+func (l *Limiter) Hit(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.prune(now)
+
+	w, ok := l.attempts[key]
+	if !ok || now.Sub(w.start) >= l.window {
+		l.attempts[key] = &window{start: now, count: 1}
+		return true
+	}
+	if w.count >= l.max {
+		return false
+	}
+	w.count++
+	return true
+}
+
+// Release gives back an attempt reserved with Hit, for a guess that turned out to be right
+func (l *Limiter) Release(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	w, ok := l.attempts[key]
+	if ok && w.count > 0 {
+		w.count--
+	}
+}
+
+// Reset forgets the attempts recorded for the key
+func (l *Limiter) Reset(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.attempts, key)
+}
+
+// prune drops the windows that are over, at most once per window so the map doesn't grow forever.
+// the caller must hold the lock
+func (l *Limiter) prune(now time.Time) {
+	if now.Sub(l.lastPrune) < l.window {
+		return
+	}
+	for key, w := range l.attempts {
+		if now.Sub(w.start) >= l.window {
+			delete(l.attempts, key)
+		}
+	}
+	l.lastPrune = now
+}
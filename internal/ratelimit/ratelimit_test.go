@@ -0,0 +1,69 @@
+package ratelimit
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// the attempts made at the same time must not get past the limit together
+func TestHitConcurrent(t *testing.T) {
+	l := New(5, time.Minute)
+
+	var allowed atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if l.Hit("key") {
+				allowed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := allowed.Load(); got != 5 {
+		t.Errorf("got %d allowed attempts; want 5", got)
+	}
+	if l.Allow("key") {
+		t.Error("Allow returned true once the limit was reached")
+	}
+}
+
+func TestRelease(t *testing.T) {
+	l := New(2, time.Minute)
+
+	for i := 0; i < 2; i++ {
+		if !l.Hit("key") {
+			t.Fatalf("attempt %d was refused", i+1)
+		}
+	}
+	if l.Hit("key") {
+		t.Fatal("attempt over the limit was allowed")
+	}
+
+	l.Release("key")
+	if !l.Hit("key") {
+		t.Error("released attempt can't be made again")
+	}
+	if !l.Hit("other") {
+		t.Error("the attempts of another key were counted")
+	}
+}
+
+func TestHitWindow(t *testing.T) {
+	l := New(1, 10*time.Millisecond)
+
+	if !l.Hit("key") {
+		t.Fatal("first attempt was refused")
+	}
+	if l.Hit("key") {
+		t.Fatal("second attempt was allowed")
+	}
+	time.Sleep(20 * time.Millisecond)
+	if !l.Hit("key") {
+		t.Error("attempt after the window was refused")
+	}
+}
@@ -0,0 +1,152 @@
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// the SHA-1 secret of the test vectors in appendix B of RFC 6238
+var rfcSecret = encoding.EncodeToString([]byte("12345678901234567890"))
+
+func TestCodeRFC6238(t *testing.T) {
+	//the RFC lists 8 digit codes, ours are their last 6 digits
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{unix: 59, want: "94287082"},
+		{unix: 1111111109, want: "07081804"},
+		{unix: 1111111111, want: "14050471"},
+		{unix: 1234567890, want: "89005924"},
+		{unix: 2000000000, want: "69279037"},
+		{unix: 20000000000, want: "65353130"},
+	}
+
+	for _, tt := range tests {
+		t.Run(time.Unix(tt.unix, 0).UTC().Format(time.RFC3339), func(t *testing.T) {
+			got, err := Code(rfcSecret, Step(time.Unix(tt.unix, 0)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := tt.want[len(tt.want)-Digits:]
+			if got != want {
+				t.Errorf("got %q; want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestCodeSecret(t *testing.T) {
+	//authenticator apps show the secret in upper case, but some users type it in lower case
+	upper, err := Code(rfcSecret, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lower, err := Code(strings.ToLower(rfcSecret), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if upper != lower {
+		t.Errorf("got %q for the lower case secret; want %q", lower, upper)
+	}
+
+	_, err = Code("not base32!", 1)
+	if err == nil {
+		t.Error("got no error for an invalid secret")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	step := Step(now)
+
+	code := func(step int64) string {
+		c, err := Code(rfcSecret, step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	tests := []struct {
+		name   string
+		code   string
+		want   int64
+		wantOK bool
+	}{
+		{name: "Current period", code: code(step), want: step, wantOK: true},
+		{name: "Previous period", code: code(step - 1), want: step - 1, wantOK: true},
+		{name: "Next period", code: code(step + 1), want: step + 1, wantOK: true},
+		{name: "Two periods ago", code: code(step - 2), wantOK: false},
+		{name: "Two periods ahead", code: code(step + 2), wantOK: false},
+		{name: "With spaces", code: code(step)[:3] + " " + code(step)[3:], want: step, wantOK: true},
+		{name: "Too short", code: code(step)[1:], wantOK: false},
+		{name: "Too long", code: code(step) + "0", wantOK: false},
+		{name: "Empty", code: "", wantOK: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := Validate(rfcSecret, tt.code, now)
+			if ok != tt.wantOK {
+				t.Fatalf("got ok %t; want %t", ok, tt.wantOK)
+			}
+			if ok && got != tt.want {
+				t.Errorf("got step %d; want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+// the window moves with the clock: at the last second of a period the next code is accepted, but not the one after it
+func TestValidateBoundary(t *testing.T) {
+	end := time.Unix(Step(time.Unix(1234567890, 0))*int64(Period/time.Second)+29, 0)
+	step := Step(end)
+
+	for _, tt := range []struct {
+		step int64
+		want bool
+	}{
+		{step: step - 1, want: true},
+		{step: step + 1, want: true},
+		{step: step + 2, want: false},
+	} {
+		c, err := Code(rfcSecret, tt.step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := Validate(rfcSecret, c, end); ok != tt.want {
+			t.Errorf("step %d: got ok %t; want %t", tt.step-step, ok, tt.want)
+		}
+		//a second later the period changed and the window with it
+		if _, ok := Validate(rfcSecret, c, end.Add(time.Second)); ok != (tt.step > step-1) {
+			t.Errorf("step %d a second later: got ok %t; want %t", tt.step-step, ok, tt.step > step-1)
+		}
+	}
+}
+
+func TestNewSecret(t *testing.T) {
+	a, err := NewSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a == b {
+		t.Error("got the same secret twice")
+	}
+
+	key, err := encoding.DecodeString(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(key) != 20 {
+		t.Errorf("got a %d byte secret; want 20", len(key))
+	}
+	if strings.Contains(a, "=") {
+		t.Errorf("got padding in %q", a)
+	}
+}
@@ -10,6 +10,15 @@ import (
 
 var EmailRX = regexp.MustCompile("^\\w+(?:\\.\\w+)*@\\w+(?:\\.[\\w-]+)*\\.[a-zA-Z]{2,}$")
 
+// tags are lowercase letters and digits, plus a few symbols used in language names such as c++, c# or node.js
+var TagRX = regexp.MustCompile(`^[a-z0-9][a-z0-9_.+#-]*$`)
+
+// content encrypted in the browser, the base64url encoded AES-GCM nonce and ciphertext separated by a dot
+var CiphertextRX = regexp.MustCompile(`^[A-Za-z0-9_-]{16}\.[A-Za-z0-9_-]+$`)
+
+// file names of snippets can't contain path separators or control characters
+var FilenameRX = regexp.MustCompile(`^[^/\\\x00-\x1f]*$`)
+
 // Validator struct that holds a map of the validation errors
 type Validator struct {
 	FieldErrors    map[string]string
@@ -64,6 +73,16 @@ func PermittedInt(value int, permittedValues ...int) bool {
 	return false
 }
 
+// PermittedString() returns true if a value is in a list of permitted strings.
+func PermittedString(value string, permittedValues ...string) bool {
+	for i := range permittedValues {
+		if value == permittedValues[i] {
+			return true
+		}
+	}
+	return false
+}
+
 // check is the length is greater than a given length
 func MinChars(value string, n int) bool {
 	return utf8.RuneCountInString(value) >= n
@@ -73,3 +92,28 @@ func MinChars(value string, n int) bool {
 func Matches(value string, rx *regexp.Regexp) bool {
 	return rx.MatchString(value)
 }
+
+// MaxCount() returns true if a list contains no more than n values.
+func MaxCount(values []string, n int) bool {
+	return len(values) <= n
+}
+
+// AllMaxChars() returns true if every value in a list contains no more than n characters.
+func AllMaxChars(values []string, n int) bool {
+	for _, value := range values {
+		if !MaxChars(value, n) {
+			return false
+		}
+	}
+	return true
+}
+
+// AllMatch() returns true if every value in a list matches a given regex pattern.
+func AllMatch(values []string, rx *regexp.Regexp) bool {
+	for _, value := range values {
+		if !Matches(value, rx) {
+			return false
+		}
+	}
+	return true
+}
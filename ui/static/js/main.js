@@ -5,4 +5,273 @@ for (var i = 0; i < navLinks.length; i++) {
 		link.classList.add("live");
 		break;
 	}
-}
\ No newline at end of file
+}
+
+// End-to-end encrypted snippets. The content is encrypted with AES-GCM in the browser and the key only ever lives in
+// the fragment of the url (#key=...), which browsers never send to the server. The server stores and returns the
+// base64url encoded nonce and ciphertext separated by a dot.
+var e2e = {
+	encode: function (buffer) {
+		var bytes = new Uint8Array(buffer);
+		var binary = "";
+		for (var i = 0; i < bytes.length; i++) {
+			binary += String.fromCharCode(bytes[i]);
+		}
+		return btoa(binary).replace(/\+/g, "-").replace(/\//g, "_").replace(/=+$/, "");
+	},
+
+	decode: function (text) {
+		var binary = atob(text.replace(/-/g, "+").replace(/_/g, "/"));
+		var bytes = new Uint8Array(binary.length);
+		for (var i = 0; i < binary.length; i++) {
+			bytes[i] = binary.charCodeAt(i);
+		}
+		return bytes;
+	},
+
+	// the encoded key from the fragment of the current url, or null
+	keyFromHash: function () {
+		var match = /(?:^#|&)key=([A-Za-z0-9_-]+)/.exec(window.location.hash);
+		return match ? match[1] : null;
+	},
+
+	available: function () {
+		return window.crypto && window.crypto.subtle;
+	},
+
+	newKey: function () {
+		return crypto.subtle.generateKey({name: "AES-GCM", length: 256}, true, ["encrypt", "decrypt"]).then(function (key) {
+			return crypto.subtle.exportKey("raw", key);
+		}).then(e2e.encode);
+	},
+
+	importKey: function (encodedKey) {
+		return crypto.subtle.importKey("raw", e2e.decode(encodedKey), "AES-GCM", false, ["encrypt", "decrypt"]);
+	},
+
+	encrypt: function (encodedKey, text) {
+		var iv = crypto.getRandomValues(new Uint8Array(12));
+		return e2e.importKey(encodedKey).then(function (key) {
+			return crypto.subtle.encrypt({name: "AES-GCM", iv: iv}, key, new TextEncoder().encode(text));
+		}).then(function (ciphertext) {
+			return e2e.encode(iv) + "." + e2e.encode(ciphertext);
+		});
+	},
+
+	decrypt: function (encodedKey, payload) {
+		var parts = payload.split(".");
+		return e2e.importKey(encodedKey).then(function (key) {
+			return crypto.subtle.decrypt({name: "AES-GCM", iv: e2e.decode(parts[0])}, key, e2e.decode(parts[1]));
+		}).then(function (plaintext) {
+			return new TextDecoder().decode(plaintext);
+		});
+	}
+};
+
+// decrypt the content shown on the view and revision pages
+var encryptedBlocks = document.querySelectorAll("code.encrypted");
+for (var i = 0; i < encryptedBlocks.length; i++) {
+	(function (block) {
+		var key = e2e.keyFromHash();
+		if (!key || !e2e.available()) {
+			return;
+		}
+		e2e.decrypt(key, block.getAttribute("data-ciphertext")).then(function (text) {
+			block.textContent = text;
+			block.classList.remove("encrypted");
+		}, function () {
+			block.textContent = "This snippet can't be decrypted, the key in the link is wrong.";
+		});
+	})(encryptedBlocks[i]);
+}
+
+// links and forms leading to another page of the same encrypted snippet keep the key
+var keyLinks = document.querySelectorAll(".keep-key");
+for (var i = 0; i < keyLinks.length; i++) {
+	var key = e2e.keyFromHash();
+	if (key) {
+		var attribute = keyLinks[i].tagName === "FORM" ? "action" : "href";
+		keyLinks[i].setAttribute(attribute, keyLinks[i].getAttribute(attribute).split("#")[0] + "#key=" + key);
+	}
+}
+
+// the create and edit forms encrypt the content of every file before it is sent when the "encrypted" box is checked
+var encryptableForms = document.querySelectorAll("form.encryptable");
+for (var i = 0; i < encryptableForms.length; i++) {
+	(function (form) {
+		var checkbox = form.querySelector("input[name='encrypted']");
+		var key = e2e.keyFromHash();
+		var ready = false;
+		// the files are looked up again on submit since more of them can be added
+		var contents = function () {
+			return Array.prototype.slice.call(form.querySelectorAll("textarea.file-content"));
+		};
+
+		// the form is re-displayed with the ciphertext after a validation error or when editing an encrypted snippet
+		if (checkbox.checked) {
+			contents().forEach(function (content) {
+				if (content.value === "") {
+					return;
+				}
+				if (key && e2e.available()) {
+					e2e.decrypt(key, content.value).then(function (text) {
+						content.value = text;
+					});
+				} else {
+					// without the key the content can only be sent back unchanged
+					content.readOnly = true;
+					ready = true;
+				}
+			});
+			if (ready) {
+				checkbox.addEventListener("click", function (event) {
+					event.preventDefault();
+				});
+			}
+		}
+
+		form.addEventListener("submit", function (event) {
+			if (ready || !checkbox.checked) {
+				return;
+			}
+			event.preventDefault();
+			if (!e2e.available()) {
+				alert("This browser can't encrypt snippets, encryption needs a secure (https) connection.");
+				return;
+			}
+
+			(key ? Promise.resolve(key) : e2e.newKey()).then(function (encodedKey) {
+				key = encodedKey;
+				// empty files are left empty so the server still drops them
+				return Promise.all(contents().map(function (content) {
+					if (content.value === "") {
+						return null;
+					}
+					return e2e.encrypt(key, content.value).then(function (ciphertext) {
+						content.value = ciphertext;
+					});
+				}));
+			}).then(function () {
+				form.setAttribute("action", form.getAttribute("action").split("#")[0] + "#key=" + key);
+				ready = true;
+				form.submit();
+			});
+		});
+	})(encryptableForms[i]);
+}
+
+// the snippet forms end with an empty file, the "add a file" button appends a copy of it with the next index in the
+// field names: files[3].name becomes files[4].name
+var fileLists = document.querySelectorAll("div.files");
+for (var i = 0; i < fileLists.length; i++) {
+	(function (list) {
+		var button = list.querySelector("button.add-file");
+		button.hidden = false;
+		button.addEventListener("click", function () {
+			var files = list.querySelectorAll("fieldset.file");
+			var last = files[files.length - 1];
+			var copy = last.cloneNode(true);
+			var fields = copy.querySelectorAll("input, select, textarea");
+			for (var j = 0; j < fields.length; j++) {
+				fields[j].name = fields[j].name.replace(/^files\[\d+\]/, "files[" + files.length + "]");
+				fields[j].value = "";
+				fields[j].readOnly = false;
+			}
+			var errors = copy.querySelectorAll("label.error");
+			for (var j = 0; j < errors.length; j++) {
+				errors[j].parentNode.removeChild(errors[j]);
+			}
+			last.parentNode.insertBefore(copy, last.nextSibling);
+			copy.querySelector("input").focus();
+		});
+	})(fileLists[i]);
+}
+
+// Line anchors. Every line number links to #L12, or #F2-L12 in the second file and so on, shift-clicking a second
+// number in the same file selects the range #L12-L20, and the selected lines are highlighted. The server highlights
+// the same range when it is given as ?lines=L12-L20, which is what the "copy link to lines" button uses so the
+// highlight survives tools that drop the fragment.
+var lineSelection = {
+	prefix: "L",
+	first: 0,
+	last: 0,
+
+	// the <span class="line"> wrapping a line, its number carries the L12 id
+	line: function (number) {
+		var anchor = document.getElementById(lineSelection.prefix + number);
+		return anchor ? anchor.parentNode : null;
+	},
+
+	select: function (prefix, first, last) {
+		if (last < first) {
+			var swap = first;
+			first = last;
+			last = swap;
+		}
+		var highlighted = document.querySelectorAll(".chroma .line.hl");
+		for (var i = 0; i < highlighted.length; i++) {
+			highlighted[i].classList.remove("hl");
+		}
+		lineSelection.prefix = prefix;
+		for (var n = first; n <= last; n++) {
+			var line = lineSelection.line(n);
+			if (line) {
+				line.classList.add("hl");
+			}
+		}
+		lineSelection.first = first;
+		lineSelection.last = last;
+
+		var buttons = document.querySelectorAll("button.copy-lines");
+		for (var i = 0; i < buttons.length; i++) {
+			buttons[i].hidden = false;
+		}
+	},
+
+	// L12, L12-L20 or F2-L12-L20 without the leading #
+	fragment: function () {
+		var fragment = lineSelection.prefix + lineSelection.first;
+		if (lineSelection.last !== lineSelection.first) {
+			fragment += "-L" + lineSelection.last;
+		}
+		return fragment;
+	}
+};
+
+var lineHash = /^#(F\d+-)?L(\d+)(?:-L(\d+))?$/.exec(window.location.hash);
+if (lineHash) {
+	var first = parseInt(lineHash[2], 10);
+	lineSelection.select((lineHash[1] || "") + "L", first, lineHash[3] ? parseInt(lineHash[3], 10) : first);
+	var firstLine = lineSelection.line(lineSelection.first);
+	if (firstLine) {
+		firstLine.scrollIntoView();
+	}
+}
+
+var lineLinks = document.querySelectorAll(".chroma a.lnlinks");
+for (var i = 0; i < lineLinks.length; i++) {
+	lineLinks[i].addEventListener("click", function (event) {
+		event.preventDefault();
+		var id = /^(.*L)(\d+)$/.exec(this.parentNode.id);
+		var number = parseInt(id[2], 10);
+		if (event.shiftKey && lineSelection.first && lineSelection.prefix === id[1]) {
+			lineSelection.select(id[1], lineSelection.first, number);
+		} else {
+			lineSelection.select(id[1], number, number);
+		}
+		// replaceState doesn't scroll the page the way setting location.hash would
+		history.replaceState(null, "", "#" + lineSelection.fragment());
+	});
+}
+
+var copyLineButtons = document.querySelectorAll("button.copy-lines");
+for (var i = 0; i < copyLineButtons.length; i++) {
+	copyLineButtons[i].addEventListener("click", function () {
+		var button = this;
+		var fragment = lineSelection.fragment();
+		var url = window.location.origin + window.location.pathname + "?lines=" + fragment + "#" + fragment;
+		navigator.clipboard.writeText(url).then(function () {
+			button.textContent = "Link copied";
+		});
+	});
+}